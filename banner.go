@@ -0,0 +1,85 @@
+package rerun
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// ANSI escape sequences for the banner's color
+const (
+	bannerColor = "\033[36m"
+	errorColor  = "\033[31m"
+	passColor   = "\033[32m"
+	resetColor  = "\033[0m"
+)
+
+// errorSummaryLines is how many lines of the last failed run's stderr are
+// printed with ErrorSummary
+const errorSummaryLines = 10
+
+// banner returns the line printed before each run describing why it's
+// running
+func (r *Rerun) banner(reason reason) string {
+	var cause string
+	switch reason.event {
+	case reasonInitial:
+		cause = "initial run"
+	case reasonKeepalive:
+		cause = "restarted by keepalive"
+	case reasonManual:
+		cause = "rerun requested"
+	default:
+		cause = "changed"
+		if len(reason.changed) > 0 {
+			cause = "triggered by " + r.relPath(reason.changed[len(reason.changed)-1])
+		}
+	}
+	_, command := r.stepsFor(reason)
+	line := fmt.Sprintf("── rerun: %s (%s, %s) ──", command, cause, time.Now().Format("15:04:05"))
+	if r.NoColor || r.Stdout != nil || !isTerminal(os.Stdout) {
+		return line
+	}
+	return bannerColor + line + resetColor
+}
+
+// resultBanner returns the line printed with OnlyOnResultChange after a run
+// which started passing or failing
+func (r *Rerun) resultBanner(code int) string {
+	line := fmt.Sprintf("── rerun: %s is passing (%s) ──", r.currentCommand(), time.Now().Format("15:04:05"))
+	color := passColor
+	if code != 0 {
+		line = fmt.Sprintf("── rerun: %s is failing with exit %d (%s) ──", r.currentCommand(), code, time.Now().Format("15:04:05"))
+		color = errorColor
+	}
+	if r.NoColor || r.Stdout != nil || !isTerminal(os.Stdout) {
+		return line
+	}
+	return color + line + resetColor
+}
+
+// isTerminal reports whether the file is a terminal rather than a pipe or
+// regular file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// failure is the exit code and the end of the stderr of a run which failed
+type failure struct {
+	code int
+	tail string
+}
+
+// errorSummary returns the reminder printed before the run after one which
+// failed with ErrorSummary
+func (r *Rerun) errorSummary(f *failure) string {
+	line := fmt.Sprintf("── previous run failed with exit %d ──", f.code)
+	if !r.NoColor && r.Stderr == nil && isTerminal(os.Stderr) {
+		line = errorColor + line + resetColor
+	}
+	if f.tail == "" {
+		return line
+	}
+	return line + "\n" + f.tail
+}
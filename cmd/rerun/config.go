@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jeffxf/rerun"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultConfigFile is loaded from the working directory when --config isn't
+// given
+const defaultConfigFile = ".rerun.yaml"
+
+// configFile holds the settings which can be given in a config file
+type configFile struct {
+	Command    string   `yaml:"command"`
+	Dirs       []string `yaml:"dirs"`
+	Ignore     []string `yaml:"ignore"`
+	IgnoreDirs []string `yaml:"ignore_dirs"`
+	Include    []string `yaml:"include"`
+	Rules      []string `yaml:"rules"`
+	Debounce   string   `yaml:"debounce"`
+	Delay      string   `yaml:"delay"`
+	Shell      string   `yaml:"shell"`
+	LogLevel   string   `yaml:"log_level"`
+}
+
+// loadConfigFile reads the config file at path. A missing default config
+// file isn't an error and returns nil
+func loadConfigFile(path string, explicit bool) (*configFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read config file: %q", err)
+	}
+
+	file := &configFile{}
+	if err := yaml.UnmarshalStrict(data, file); err != nil {
+		return nil, fmt.Errorf("Unable to parse config file %s: %q", path, err)
+	}
+
+	// Dirs are relative to the directory containing the config file
+	for i, dir := range file.Dirs {
+		if !filepath.IsAbs(dir) {
+			file.Dirs[i] = filepath.Join(filepath.Dir(path), dir)
+		}
+	}
+	return file, nil
+}
+
+// apply sets each value from the config file which wasn't given as a flag.
+// The log level is always set since --debug and --verbose are applied after
+func (file *configFile) apply(config *rerun.Config, set map[string]bool) error {
+	if config.Command == "" && len(config.Args) == 0 && len(config.Commands) == 0 {
+		config.Command = file.Command
+	}
+	if !set["--dir"] {
+		config.Dirs = file.Dirs
+	}
+	if !set["--ignore"] {
+		config.Ignore = file.Ignore
+	}
+	if !set["--ignore-dir"] {
+		config.IgnoreDirs = file.IgnoreDirs
+	}
+	if !set["--include"] {
+		config.Include = file.Include
+	}
+	for _, pattern := range append(append(file.Ignore, file.IgnoreDirs...), file.Include...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid pattern in config file: %q", pattern)
+		}
+	}
+	if !set["--rule"] && !set["--restart-on"] {
+		for _, value := range file.Rules {
+			rule, err := rerun.ParseRule(value)
+			if err != nil {
+				return fmt.Errorf("Invalid rule in config file: %q", err)
+			}
+			config.Rules = append(config.Rules, rule)
+		}
+	}
+	if !set["--debounce"] && file.Debounce != "" {
+		debounce, err := time.ParseDuration(file.Debounce)
+		if err != nil {
+			return fmt.Errorf("Invalid duration for debounce in config file: %q", file.Debounce)
+		}
+		config.Debounce = debounce
+	}
+	if !set["--delay"] && file.Delay != "" {
+		delay, err := time.ParseDuration(file.Delay)
+		if err != nil {
+			return fmt.Errorf("Invalid duration for delay in config file: %q", file.Delay)
+		}
+		config.Delay = delay
+	}
+	if !set["--shell"] && file.Shell != "" {
+		config.Shell = file.Shell
+	}
+	if file.LogLevel != "" {
+		level, err := log.ParseLevel(file.LogLevel)
+		if err != nil {
+			return fmt.Errorf("Invalid log_level in config file: %q", file.LogLevel)
+		}
+		log.SetLevel(level)
+	}
+	return nil
+}
+
+// reloadConfigFile returns the base config, from the flags, with the config
+// file applied again for SIGHUP. The log level isn't reloaded since it would
+// override --verbose and --debug
+func reloadConfigFile(base rerun.Config, path string, set map[string]bool) (rerun.Config, error) {
+	file, err := loadConfigFile(path, set["--config"])
+	if err != nil || file == nil {
+		return base, err
+	}
+	level := log.GetLevel()
+	defer log.SetLevel(level)
+	base.Rules = append([]rerun.Rule(nil), base.Rules...)
+	err = file.apply(&base, set)
+	return base, err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes the config file into a temporary directory, returning
+// its path
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ".rerun.yaml")
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeConfig(t, `command: go test ./...
+dirs: [cmd]
+ignore: ["*.log"]
+ignore_dirs: [node_modules]
+include: ["*.go"]
+rules: ["*.go=restart"]
+debounce: 200ms
+delay: 1s
+shell: bash
+log_level: debug
+`)
+	file, err := loadConfigFile(path, true)
+	if err != nil {
+		t.Fatalf("loadConfigFile() failed: %v", err)
+	}
+	want := &configFile{
+		Command:    "go test ./...",
+		Dirs:       []string{filepath.Join(filepath.Dir(path), "cmd")},
+		Ignore:     []string{"*.log"},
+		IgnoreDirs: []string{"node_modules"},
+		Include:    []string{"*.go"},
+		Rules:      []string{"*.go=restart"},
+		Debounce:   "200ms",
+		Delay:      "1s",
+		Shell:      "bash",
+		LogLevel:   "debug",
+	}
+	if !reflect.DeepEqual(file, want) {
+		t.Errorf("loadConfigFile() = %+v, want %+v", file, want)
+	}
+}
+
+func TestLoadConfigFileUnknownKey(t *testing.T) {
+	for _, key := range []string{"grace: 1s", "keepalive: true", "env: [A=1]", "prefix: api"} {
+		_, err := loadConfigFile(writeConfig(t, "command: make\n"+key+"\n"), true)
+		if err == nil || !strings.Contains(err.Error(), strings.SplitN(key, ":", 2)[0]) {
+			t.Errorf("loadConfigFile() with %q = %v, want an error about the key", key, err)
+		}
+	}
+}
+
+func TestLoadConfigFileMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".rerun.yaml")
+	if file, err := loadConfigFile(path, false); file != nil || err != nil {
+		t.Errorf("loadConfigFile() for a missing default file = %+v, %v, want nil", file, err)
+	}
+	if _, err := loadConfigFile(path, true); err == nil {
+		t.Error("loadConfigFile() for a missing --config file succeeded")
+	}
+}
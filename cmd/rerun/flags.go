@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeffxf/rerun"
+)
+
+// version is set when building releases with -ldflags "-X main.version=..."
+var version = ""
+
+// currentVersion returns version or the module version rerun was built from
+func currentVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// stringsFlag collects each value of a flag which may be repeated
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// funcFlag checks and applies the value of a flag with the function
+type funcFlag func(string) error
+
+func (f funcFlag) String() string {
+	return ""
+}
+
+func (f funcFlag) Set(value string) error {
+	return f(value)
+}
+
+// positiveDuration is a duration flag which must be greater than zero
+type positiveDuration struct {
+	duration *time.Duration
+}
+
+func (d positiveDuration) String() string {
+	if d.duration == nil || *d.duration == 0 {
+		return ""
+	}
+	return d.duration.String()
+}
+
+func (d positiveDuration) Set(value string) error {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return errors.New("invalid duration")
+	}
+	if duration <= 0 {
+		return errors.New("must be greater than zero")
+	}
+	*d.duration = duration
+	return nil
+}
+
+// parseSize parses a number of bytes with an optional K, M or G suffix
+func parseSize(value string) (int64, error) {
+	if value == "" {
+		return 0, errors.New("Empty size")
+	}
+	multiplier := int64(1)
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	return size * multiplier, err
+}
+
+// goPreset is what --go sets for each flag which isn't given, for the usual
+// Go project's sources, modules and vendored dependencies
+var goPreset = struct {
+	include    []string
+	ignoreDirs []string
+	debounce   time.Duration
+}{
+	include:    []string{"*.go", "go.mod", "go.sum", "go.work"},
+	ignoreDirs: []string{"vendor"},
+	debounce:   100 * time.Millisecond,
+}
+
+// applyGoPreset fills in the settings from goPreset which weren't given as
+// flags or in the config file
+func applyGoPreset(config *rerun.Config, set map[string]bool) {
+	if !set["--include"] && len(config.Include) == 0 {
+		config.Include = goPreset.include
+	}
+	if !set["--ignore-dir"] && len(config.IgnoreDirs) == 0 {
+		config.IgnoreDirs = goPreset.ignoreDirs
+	}
+	if !set["--debounce"] && config.Debounce == 0 {
+		config.Debounce = goPreset.debounce
+	}
+}
+
+// printUsage prints how to run rerun followed by each of its flags
+func printUsage(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintln(w, "Usage: rerun [flags] <command>")
+	fmt.Fprintln(w, "       rerun [flags] -- <program> [args...]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Flags:")
+	flags.VisitAll(func(f *flag.Flag) {
+		// -v is listed along with --verbose
+		if f.Name == "v" {
+			return
+		}
+		name, usage := flag.UnquoteUsage(f)
+		line := "  --" + f.Name
+		if f.Name == "verbose" {
+			line = "  -v, --verbose"
+		}
+		if name != "" {
+			line += " <" + name + ">"
+		}
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "true" {
+			usage += fmt.Sprintf(" (default %s)", f.DefValue)
+		}
+		fmt.Fprintf(w, "%s\n    \t%s\n", line, strings.Replace(usage, "\n", "\n    \t", -1))
+	})
+}
+
+// terminated reports whether the flags parsed from args ended with --, rather
+// than -- being the value of a flag such as --prefix --
+func terminated(flags *flag.FlagSet, args []string) bool {
+	consumed := len(args) - flags.NArg()
+	for i := 0; i < consumed; i++ {
+		arg := args[i]
+		if arg == "--" {
+			return true
+		}
+		// Skip the value of flags which take one as the next argument
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		f := flags.Lookup(name)
+		if f == nil {
+			continue
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			continue
+		}
+		i++
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestTerminated(t *testing.T) {
+	for _, test := range []struct {
+		args       []string
+		terminated bool
+		rest       []string
+	}{
+		{[]string{"echo", "hi"}, false, []string{"echo", "hi"}},
+		{[]string{"--debug", "echo", "hi"}, false, []string{"echo", "hi"}},
+		{[]string{"--", "echo", "hi"}, true, []string{"echo", "hi"}},
+		{[]string{"--debug", "--", "sh", "-c", "a | b"}, true, []string{"sh", "-c", "a | b"}},
+		{[]string{"--debug=true", "--", "echo"}, true, []string{"echo"}},
+		{[]string{"-debug", "--", "echo"}, true, []string{"echo"}},
+		// -- is the value of --prefix rather than ending the flags
+		{[]string{"--prefix", "--", "echo", "hi"}, false, []string{"echo", "hi"}},
+		{[]string{"--prefix", "--", "--", "echo"}, true, []string{"echo"}},
+		{[]string{"--prefix=--", "--", "echo"}, true, []string{"echo"}},
+		{[]string{"--prefix=--", "echo"}, false, []string{"echo"}},
+		{[]string{"--dir", "src", "--prefix", "--", "echo", "--"}, false, []string{"echo", "--"}},
+	} {
+		flags := flag.NewFlagSet("rerun", flag.ContinueOnError)
+		flags.SetOutput(ioutil.Discard)
+		flags.Bool("debug", false, "")
+		flags.String("prefix", "", "")
+		flags.String("dir", "", "")
+		if err := flags.Parse(test.args); err != nil {
+			t.Fatalf("Parse(%q) failed: %v", test.args, err)
+		}
+		if got := terminated(flags, test.args); got != test.terminated {
+			t.Errorf("terminated(%q) = %v, want %v", test.args, got, test.terminated)
+		}
+		if !reflect.DeepEqual(flags.Args(), test.rest) {
+			t.Errorf("Parse(%q) left %q, want %q", test.args, flags.Args(), test.rest)
+		}
+	}
+}
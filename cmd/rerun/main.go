@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/jeffxf/rerun"
+	log "github.com/sirupsen/logrus"
+)
+
+// exitUsage is the exit code for invalid flags or a missing command
+const exitUsage = 2
+
+// usageError prints the error and exits with exitUsage
+func usageError(err error) {
+	fmt.Println(err)
+	os.Exit(exitUsage)
+}
+
+// watchOnly are the flags which only apply when watching for changes
+var watchOnly = map[string]bool{
+	"also-watch": true, "debounce": true, "debounce-per-dir": true, "delay": true, "dir": true, "filter-op": true, "follow-symlinks": true,
+	"go": true, "hidden": true, "http": true, "hup-restart": true, "ignore": true, "ignore-dir": true, "ignore-self-changes": true,
+	"include": true, "interval": true, "keepalive": true, "map": true, "max-restarts": true, "metrics": true,
+	"no-editor-ignore": true, "no-gitignore": true, "no-kill-on-change": true, "no-recursive": true, "poll": true, "polling": true, "recursive-depth": true, "restart-delay": true, "restart-on": true, "rule": true,
+	"serve": true, "serve-addr": true, "settle": true, "signal": true, "since": true, "socket": true, "throttle": true, "tui": true, "until": true, "watch": true, "watch-file": true, "watch-from": true, "watch-new-only": true,
+	"while": true,
+}
+
+func main() {
+	var debug, verbose, quiet, once, list, notify, showVersion, useTUI, goProject bool
+	var alsoWatch, commands, dirs, env, files, ignore, ignoreDirs, include, watch stringsFlag
+	runOnStart := true
+	configPath := defaultConfigFile
+	config := rerun.Config{Grace: rerun.DefaultGrace, Banner: true}
+
+	flags := flag.NewFlagSet("rerun", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	flags.BoolVar(&verbose, "v", false, "")
+	flags.BoolVar(&verbose, "verbose", false, "Log each rerun, the file which triggered it and the command's exit status")
+	flags.BoolVar(&debug, "debug", false, "Enable debug logging, including the caller of each log line")
+	flags.BoolVar(&quiet, "quiet", false, "Only print the command's output and errors")
+	flags.BoolVar(&showVersion, "version", false, "Print the version and exit")
+	flags.Var(&alsoWatch, "also-watch", "Also watch the file or directory at `path` outside of --dir, may be repeated")
+	flags.BoolVar(&config.Clear, "clear", false, "Clear the terminal before each run")
+	flags.BoolVar(&config.ClearScrollback, "clear-scrollback", false, "Clear the terminal and its scrollback before each run")
+	flags.StringVar(&configPath, "config", defaultConfigFile, "Read settings from the config file at `path`")
+	flags.Var(&commands, "cmd", "Run the `command` on each change, may be repeated")
+	flags.StringVar(&config.StdinFile, "cmd-stdin", "", "Read the file at `path` as the command's stdin, opening it again for each run")
+	flags.StringVar(&config.Dir, "cwd", "", "Run the command in the directory at `path`")
+	flags.DurationVar(&config.Debounce, "debounce", 0, "Wait until no events have arrived for the `duration` before rerunning")
+	flags.BoolVar(&config.DebouncePerDir, "debounce-per-dir", false, "Time --delay and --debounce separately for each --dir")
+	flags.StringVar(&config.DefaultFile, "default-file", "", "Expand {file} and the other placeholders in the command for the `path` when nothing changed")
+	flags.DurationVar(&config.Delay, "delay", 0, "Wait for the `duration` after the first event before rerunning")
+	flags.Var(&dirs, "dir", "Watch the directory at `path` instead of the current directory, may be repeated")
+	flags.Var(funcFlag(func(value string) error {
+		if strings.Index(value, "=") <= 0 {
+			return errors.New("must be KEY=VALUE")
+		}
+		return env.Set(value)
+	}), "env", "Set the environment variable `key=value` for the command, may be repeated")
+	flags.BoolVar(&config.ErrorSummary, "error-summary", false, "Print the end of a failed run's stderr again before the next run")
+	flags.StringVar(&config.OnReady, "exec-on-ready", "", "Run the `command` once, after the first successful run or once --wait-for is ready")
+	flags.BoolVar(&config.FailFast, "fail-fast", false, "Stop running the --cmd commands at the first which fails")
+	flags.BoolVar(&config.FailOnStderr, "fail-on-stderr", false, "Treat runs which write anything to stderr as failed, with exit status 1")
+	flags.Var(funcFlag(func(value string) error {
+		op, err := rerun.ParseOps(value)
+		config.Ops = op
+		return err
+	}), "filter-op", "Only rerun for events with one of the comma separated `ops`, such as write,create")
+	flags.BoolVar(&config.FollowSymlinks, "follow-symlinks", false, "Watch the directories symlinks point to")
+	flags.BoolVar(&goProject, "go", false, "Only rerun for Go sources and modules, ignoring vendor, unless --include, --ignore-dir or --debounce are given")
+	flags.DurationVar(&config.Grace, "grace", rerun.DefaultGrace, "How long to wait after SIGTERM before killing the command, as a `duration`")
+	flags.StringVar(&config.Group, "group", "", "Run the command as the `group`, by name or ID")
+	flags.Var(positiveDuration{&config.HookTimeout}, "hook-timeout", "How long --on-success and --on-failure may run, as a `duration`, defaults to 30s")
+	flags.BoolVar(&config.Hidden, "hidden", false, "Watch hidden directories, which start with a dot, other than .git")
+	flags.StringVar(&config.HTTPAddr, "http", "", "Serve the HTTP control endpoints on the `addr`")
+	flags.BoolVar(&config.RestartOnSIGHUP, "hup-restart", false, "Restart the command on SIGHUP instead of reloading the config file")
+	flags.Var(funcFlag(func(value string) error {
+		if _, err := filepath.Match(value, ""); err != nil {
+			return errors.New("invalid pattern")
+		}
+		return ignore.Set(value)
+	}), "ignore", "Ignore paths matching the `glob`, may be repeated")
+	flags.Var(funcFlag(func(value string) error {
+		if _, err := filepath.Match(value, ""); err != nil {
+			return errors.New("invalid pattern")
+		}
+		return ignoreDirs.Set(value)
+	}), "ignore-dir", "Don't watch or walk directories matching the `glob`, may be repeated")
+	flags.BoolVar(&config.IgnoreSelfChanges, "ignore-self-changes", false, "Ignore changes made while the command is running and shortly after")
+	flags.Var(funcFlag(func(value string) error {
+		if _, err := filepath.Match(value, ""); err != nil {
+			return errors.New("invalid pattern")
+		}
+		return include.Set(value)
+	}), "include", "Only rerun for paths matching the `glob`, may be repeated")
+	flags.Var(positiveDuration{&config.InitialDelay}, "initial-delay", "Wait for the `duration` before the first run")
+	flags.Var(positiveDuration{&config.RestartInterval}, "interval", "The window for --max-restarts as a `duration`, defaults to 10s")
+	flags.BoolVar(&config.Keepalive, "keepalive", false, "Restart the command with a backoff if it fails")
+	flags.Var(funcFlag(func(value string) error {
+		if strings.TrimPrefix(strings.ToUpper(value), "SIG") == "KILL" {
+			config.KillSignal = syscall.SIGKILL
+			return nil
+		}
+		sig, err := rerun.ParseSignal(value)
+		if err != nil {
+			return errors.New("unknown signal")
+		}
+		config.KillSignal = sig
+		return nil
+	}), "kill-signal", "Send the `signal` to stop the command instead of SIGTERM, or KILL to kill it straight away")
+	flags.BoolVar(&list, "list", false, "Print the directories which would be watched and exit, as JSON with --log-format json")
+	flags.StringVar(&config.LogFile, "log-file", "", "Append the command's output to the file at `path`")
+	flags.Var(funcFlag(func(value string) error {
+		size, err := parseSize(value)
+		if err != nil || size <= 0 {
+			return errors.New("invalid size")
+		}
+		config.LogFileMaxSize = size
+		return nil
+	}), "log-file-max-size", "Rotate the log file when it would grow past the `size`, such as 10M")
+	flags.Var(funcFlag(func(value string) error {
+		switch value {
+		case "text":
+			log.SetFormatter(&log.TextFormatter{})
+		case "json":
+			log.SetFormatter(&log.JSONFormatter{})
+		default:
+			return errors.New("must be text or json")
+		}
+		return nil
+	}), "log-format", "Log as text or json, the `format`")
+	flags.Var(funcFlag(func(value string) error {
+		m, err := rerun.ParseCommandMap(value)
+		config.CommandMaps = append(config.CommandMaps, m)
+		return err
+	}), "map", "Run a different command for paths matching a glob, as `glob=command`, may be repeated")
+	flags.Var(funcFlag(func(value string) error {
+		restarts, err := strconv.Atoi(value)
+		if err != nil || restarts <= 0 {
+			return errors.New("must be a number greater than zero")
+		}
+		config.MaxRestarts = restarts
+		return nil
+	}), "max-restarts", "Pause restarting after `n` restarts within --interval")
+	flags.StringVar(&config.MetricsAddr, "metrics", "", "Serve Prometheus metrics at /metrics on the `addr`, such as :9090")
+	flags.IntVar(&config.Nice, "nice", 0, "Run the command with the nice `value`, from 1 for slightly lower priority to 19 for the lowest")
+	flags.BoolVar(&config.NoColor, "no-color", false, "Print the banner without color")
+	flags.BoolVar(&config.NoEditorIgnore, "no-editor-ignore", false, "Don't ignore the swap and temporary files of editors")
+	flags.BoolVar(&config.NoGitignore, "no-gitignore", false, "Don't ignore paths ignored by .gitignore files")
+	flags.BoolVar(&config.NoKillOnChange, "no-kill-on-change", false, "Let the command finish when something changes and rerun it afterwards")
+	flags.BoolVar(&config.NoRecursive, "no-recursive", false, "Don't watch sub directories")
+	flags.BoolVar(&config.NoShell, "no-shell", false, "Run the command directly instead of with a shell")
+	flags.BoolVar(&notify, "notify", false, "Show a desktop notification when each run finishes")
+	flags.BoolVar(&once, "once", false, "Run the command once and exit with its exit code")
+	flags.StringVar(&config.OnFailure, "on-failure", "", "Run the `command` after each run which fails")
+	flags.StringVar(&config.OnSuccess, "on-success", "", "Run the `command` after each run which succeeds")
+	flags.BoolVar(&config.OnlyOnResultChange, "only-on-change-result", false, "Only print the banner and notify when the command starts passing or failing")
+	flags.Var(positiveDuration{&config.PollInterval}, "poll", "Poll for changes every `duration` instead of using the filesystem watcher")
+	flags.BoolVar(&config.Polling, "polling", false, "Poll for changes instead of using the filesystem watcher")
+	flags.StringVar(&config.Prefix, "prefix", "", "Start each line of output with the `prefix`")
+	flags.BoolVar(&config.PTY, "pty", false, "Run the command in a pseudo-terminal so it keeps its colors, merging its stderr into stdout")
+	flags.Var(funcFlag(func(value string) error {
+		depth, err := strconv.Atoi(value)
+		if err != nil || depth < 0 {
+			return errors.New("must be a number of levels, 0 or more")
+		}
+		// Depth 0 is only the watched directories themselves
+		config.RecursiveDepth = depth
+		if depth == 0 {
+			config.NoRecursive = true
+		}
+		return nil
+	}), "recursive-depth", "Only watch sub directories up to `levels` deep, 0 for none")
+	flags.Var(positiveDuration{&config.RestartDelay}, "restart-delay", "Wait for the `duration` after stopping the command before starting it again")
+	flags.Var(funcFlag(func(value string) error {
+		rule, err := rerun.ParseRule(value + "=restart")
+		config.Rules = append(config.Rules, rule)
+		return err
+	}), "restart-on", "Restart the command for paths matching the `glob`, the same as --rule <glob>=restart")
+	flags.Var(funcFlag(func(value string) error {
+		rule, err := rerun.ParseRule(value)
+		config.Rules = append(config.Rules, rule)
+		return err
+	}), "rule", "Restart, signal or ignore the command for paths matching a glob, as `glob=action`, may be repeated")
+	flags.BoolVar(&runOnStart, "run-on-start", true, "Run the command when rerun starts")
+	flags.StringVar(&config.Serve, "serve", "", "Proxy the dev server at the `url` and reload pages in the browser after each successful run")
+	flags.StringVar(&config.ServeAddr, "serve-addr", rerun.DefaultServeAddr, "Listen on the `addr` for --serve")
+	flags.Var(positiveDuration{&config.SelfChangeSettle}, "settle", "How long after the command exits changes are its own, as a `duration`")
+	flags.StringVar(&config.Shell, "shell", "", "Run the command with the `shell`")
+	flags.Var(funcFlag(func(value string) error {
+		sig, err := rerun.ParseSignal(value)
+		if err != nil {
+			return errors.New("unknown signal")
+		}
+		config.ReloadSignal = sig
+		return nil
+	}), "signal", "Send the `signal` to the command instead of restarting it")
+	flags.Var(positiveDuration{&config.Since}, "since", "Only watch sub directories modified within the `duration` at first")
+	flags.StringVar(&config.Socket, "socket", "", "Stream lifecycle events as JSON lines on a Unix socket at `path`")
+	flags.BoolVar(&config.Stdin, "stdin", false, "Pass rerun's stdin to the command")
+	flags.Var(positiveDuration{&config.Throttle}, "throttle", "Run the command at most once per `duration`")
+	flags.Var(positiveDuration{&config.Timeout}, "timeout", "Stop the command after the `duration`")
+	flags.BoolVar(&useTUI, "tui", false, "Show the command's status and output full screen, with keys to rerun and quit")
+	flags.Var(funcFlag(func(value string) error {
+		pattern, err := regexp.Compile(value)
+		config.Until = pattern
+		return err
+	}), "until", "Stop rerunning once the output matches the `regexp`")
+	flags.StringVar(&config.User, "user", "", "Run the command as the `user`, by name or ID")
+	flags.BoolVar(&config.WatchNewOnly, "watch-new-only", false, "Only watch sub directories once something next to them changes")
+	flags.StringVar(&config.WaitFor, "wait-for", "", "Log and notify once the command is accepting connections on the `host:port`")
+	flags.Var(positiveDuration{&config.WaitForTimeout}, "wait-for-timeout", "How long to wait for --wait-for, as a `duration`, defaults to 30s")
+	flags.Var(&watch, "watch", "Watch for paths matching the `glob`, which may not exist yet, may be repeated")
+	flags.Var(&files, "watch-file", "Watch the file at `path`, may be repeated")
+	flags.StringVar(&config.WatchFrom, "watch-from", "", "Watch the paths and globs listed in the file at `path`, reading it again when it changes")
+	flags.Var(funcFlag(func(value string) error {
+		pattern, err := regexp.Compile(value)
+		config.While = pattern
+		return err
+	}), "while", "Stop rerunning once the output doesn't match the `regexp`")
+
+	// Flags must come before the command, which starts at the first argument
+	// that isn't a flag or after --
+	err := flags.Parse(os.Args[1:])
+	if err == flag.ErrHelp {
+		printUsage(os.Stdout, flags)
+		os.Exit(0)
+	} else if err != nil {
+		usageError(fmt.Errorf("%s\nRun 'rerun --help' for usage", err))
+	}
+	if showVersion {
+		fmt.Println("rerun", currentVersion())
+		os.Exit(0)
+	}
+
+	// Flags which were given, so they override the config file, and those
+	// which only apply when watching for changes
+	set := map[string]bool{}
+	var watchFlags []string
+	flags.Visit(func(f *flag.Flag) {
+		set["--"+f.Name] = true
+		if watchOnly[f.Name] || (f.Name == "run-on-start" && !runOnStart) {
+			watchFlags = append(watchFlags, "--"+f.Name)
+		}
+	})
+	config.AlsoWatch = alsoWatch
+	config.Commands = commands
+	config.Dirs = dirs
+	config.Env = env
+	config.Files = files
+	config.Watch = watch
+	config.Ignore = ignore
+	config.IgnoreDirs = ignoreDirs
+	config.Include = include
+	config.SkipInitialRun = !runOnStart
+	if notify {
+		config.Notifier = rerun.DesktopNotifier{}
+	}
+
+	// Everything after -- is the command's literal arguments, which are
+	// passed to it exactly as given rather than through a shell
+	args := flags.Args()
+	if terminated(flags, os.Args[1:]) {
+		config.Args = args
+	}
+	if config.Args == nil {
+		config.Command = strings.Join(args, " ")
+	}
+
+	// Only log warnings and errors unless asked for more
+	log.SetLevel(log.WarnLevel)
+
+	// Fill in anything not given as a flag from the config file, which can
+	// be read again with SIGHUP
+	base := config
+	base.Rules = append([]rerun.Rule(nil), config.Rules...)
+	file, err := loadConfigFile(configPath, set["--config"])
+	if err != nil {
+		usageError(err)
+	}
+	if file != nil {
+		if err := file.apply(&config, set); err != nil {
+			usageError(err)
+		}
+	}
+	if goProject {
+		applyGoPreset(&config, set)
+	}
+	if len(config.Commands) > 0 && (config.Command != "" || config.Args != nil) {
+		usageError(errors.New("You can't provide a command along with --cmd"))
+	}
+	if config.Command == "" && len(config.Args) == 0 && len(config.Commands) == 0 && len(config.CommandMaps) == 0 && !list {
+		usageError(errors.New("You must provide a command to run"))
+	}
+	if config.Stdin && config.StdinFile != "" {
+		usageError(errors.New("You can't use --stdin with --cmd-stdin"))
+	}
+	if config.PTY && (config.Stdin || config.StdinFile != "") {
+		usageError(errors.New("You can't use --pty with --stdin or --cmd-stdin"))
+	}
+	// --debug takes precedence over --verbose and both override the config
+	// file's log level. --quiet only leaves errors and the command's output
+	if quiet && (debug || verbose) {
+		usageError(errors.New("You can't use --quiet with --verbose or --debug"))
+	}
+	if quiet {
+		log.SetLevel(log.ErrorLevel)
+		config.Banner = false
+	} else if debug {
+		if verbose {
+			log.Warn("Ignoring --verbose since --debug is enabled")
+		}
+		log.SetReportCaller(true)
+		log.SetLevel(log.DebugLevel)
+	} else if verbose {
+		log.SetLevel(log.InfoLevel)
+	}
+	if config.DebouncePerDir && len(config.Dirs) < 2 {
+		log.Warn("--debounce-per-dir only makes a difference when watching more than one --dir")
+	}
+
+	// Print the directories which would be watched without running anything,
+	// along with those skipped and why with --log-format json
+	if list {
+		if _, ok := log.StandardLogger().Formatter.(*log.JSONFormatter); ok {
+			if err := printListJSON(config); err != nil {
+				log.Fatal(err)
+			}
+			os.Exit(0)
+		}
+		dirs, err := rerun.ListDirs(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, dir := range dirs {
+			fmt.Println(dir)
+		}
+		os.Exit(0)
+	}
+
+	// Run the command a single time and exit with its exit code
+	if once {
+		if len(watchFlags) > 0 {
+			log.Warnf("Ignoring %s since --once doesn't watch for changes", strings.Join(watchFlags, ", "))
+		}
+		code, err := rerun.RunOnce(config)
+		exitOnSignal(err)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if code < 0 {
+			code = 1
+		}
+		os.Exit(code)
+	}
+
+	// The TUI draws the banner itself and logs would garble it. It starts
+	// showing the output afresh each time rerun clears the screen
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	output := &tuiOutput{}
+	if useTUI {
+		if !tuiSupported {
+			usageError(errors.New("--tui isn't supported by this build of rerun"))
+		}
+		if config.Stdin {
+			usageError(errors.New("You can't use --tui with --stdin"))
+		}
+		config.Banner = false
+		config.Clear = true
+		config.Stdout = output
+		config.Stderr = output
+		log.SetOutput(ioutil.Discard)
+	}
+
+	// The summary of the runs on exit is part of rerun's usual output, which
+	// the TUI has already cleared away by then
+	config.Summary = !quiet && !useTUI
+
+	// Only catch SIGHUP when there's a config file to reload, so rerun still
+	// exits when its terminal hangs up otherwise
+	if !config.RestartOnSIGHUP && file != nil {
+		config.ReloadConfig = func() (rerun.Config, error) {
+			reloaded, err := reloadConfigFile(base, configPath, set)
+			if goProject {
+				applyGoPreset(&reloaded, set)
+			}
+			return reloaded, err
+		}
+	}
+
+	// Initialize rerun command
+	run, err := rerun.NewRerun(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	restore := func() {}
+	if useTUI {
+		restore, err = runTUI(run, output, cancel)
+		if err != nil {
+			log.SetOutput(os.Stderr)
+			log.Fatal(err)
+		}
+	}
+
+	// Rerun the command until we're killed, or quit from the TUI
+	err = run.Run(ctx)
+	restore()
+	log.SetOutput(os.Stderr)
+	exitOnSignal(err)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// exitOnSignal exits with the signal's exit code, such as 130 for SIGINT, if
+// rerun stopped because it caught the signal
+func exitOnSignal(err error) {
+	var signalErr *rerun.SignalError
+	if errors.As(err, &signalErr) {
+		os.Exit(signalErr.ExitCode())
+	}
+}
+
+// listing is how --list prints the directories as JSON
+type listing struct {
+	Watched int               `json:"watched"`
+	Skipped int               `json:"skipped"`
+	Dirs    []rerun.ListedDir `json:"dirs"`
+}
+
+// printListJSON prints each directory which would be watched or skipped for
+// the config, and how many of each
+func printListJSON(config rerun.Config) error {
+	dirs, err := rerun.ListAllDirs(config)
+	if err != nil {
+		return err
+	}
+	out := listing{Dirs: dirs}
+	for _, dir := range dirs {
+		if dir.Watched {
+			out.Watched++
+		} else {
+			out.Skipped++
+		}
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
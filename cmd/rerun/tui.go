@@ -0,0 +1,297 @@
+//go:build !windows && !notui
+// +build !windows,!notui
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jeffxf/rerun"
+)
+
+// tuiSupported reports whether rerun was built with --tui
+const tuiSupported = true
+
+// tuiOutputLines is how many lines of the command's output the TUI keeps
+const tuiOutputLines = 1000
+
+// ANSI escape sequences for drawing the TUI
+const (
+	altScreen   = "\033[?1049h\033[?25l"
+	mainScreen  = "\033[?25h\033[?1049l"
+	clearTUI    = "\033[H\033[2J"
+	boldText    = "\033[1m"
+	plainText   = "\033[0m"
+	failedText  = "\033[31m"
+	succeedText = "\033[32m"
+)
+
+// tuiOutput keeps the most recent lines of the command's stdout and stderr
+// together for the TUI
+type tuiOutput struct {
+	mu      sync.Mutex
+	lines   []string
+	partial bytes.Buffer
+	changed bool
+}
+
+// Write implements io.Writer, forgetting the output so far when rerun clears
+// the screen for a new run
+func (o *tuiOutput) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := len(b)
+	if i := bytes.LastIndex(b, []byte(clearTUI)); i >= 0 {
+		o.lines = nil
+		o.partial.Reset()
+		b = b[i+len(clearTUI):]
+	}
+	o.partial.Write(b)
+	for {
+		line, err := o.partial.ReadString('\n')
+		if err != nil {
+			// Keep the partial line for the next write
+			rest := line
+			o.partial.Reset()
+			o.partial.WriteString(rest)
+			break
+		}
+		o.lines = append(o.lines, strings.TrimRight(line, "\r\n"))
+	}
+	if extra := len(o.lines) - tuiOutputLines; extra > 0 {
+		o.lines = o.lines[extra:]
+	}
+	o.changed = true
+	return n, nil
+}
+
+// snapshot returns the lines of output, including a partial last line, and
+// whether they changed since the last snapshot
+func (o *tuiOutput) snapshot() ([]string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	lines := append([]string(nil), o.lines...)
+	if o.partial.Len() > 0 {
+		lines = append(lines, o.partial.String())
+	}
+	changed := o.changed
+	o.changed = false
+	return lines, changed
+}
+
+// tui is the full screen view of the command's state and output
+type tui struct {
+	run    *rerun.Rerun
+	output *tuiOutput
+	// command is the command being rerun, from the last run since reloading
+	// the config can change it
+	command string
+	// started is when the current run started, zero once it has exited
+	started  time.Time
+	lastCode int
+	lastTook time.Duration
+	exited   bool
+	// scroll is how many lines up from the end of the output are shown
+	scroll int
+	rows   int
+	cols   int
+}
+
+// stty runs stty on the terminal, returning its output
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// runTUI shows the TUI until q or Ctrl+C is pressed, then cancels the context
+// to stop rerun. The returned func restores the terminal if rerun stops first
+func runTUI(run *rerun.Rerun, output *tuiOutput, cancel context.CancelFunc) (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to use the terminal for --tui: %q", err)
+	}
+	// Read keys as they're pressed, including Ctrl+C, without echoing them
+	if _, err := stty("-icanon", "-echo", "-isig", "min", "1"); err != nil {
+		return nil, fmt.Errorf("Unable to use the terminal for --tui: %q", err)
+	}
+	fmt.Print(altScreen)
+
+	var once sync.Once
+	restore := func() {
+		once.Do(func() {
+			fmt.Print(mainScreen)
+			stty(saved)
+		})
+	}
+	t := &tui{run: run, output: output, command: run.Command}
+	t.resize()
+	go func() {
+		t.loop()
+		restore()
+		cancel()
+	}()
+	return restore, nil
+}
+
+// loop redraws the TUI on changes and handles keys until quitting
+func (t *tui) loop() {
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				close(keys)
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	t.draw()
+	for {
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				return
+			}
+			switch key {
+			case 'q', 3:
+				return
+			case 'r':
+				t.run.RequestRerun()
+			case 'k':
+				t.scroll++
+			case 'j':
+				if t.scroll > 0 {
+					t.scroll--
+				}
+			case 'G':
+				t.scroll = 0
+			}
+			t.draw()
+		case event := <-t.run.Lifecycle():
+			switch event.Type {
+			case rerun.CommandStarted:
+				t.started = event.Time
+				t.command = event.Command
+				t.scroll = 0
+			case rerun.CommandExited:
+				t.started = time.Time{}
+				t.exited = true
+				t.lastCode = event.ExitCode
+				t.lastTook = event.Duration
+			case rerun.CommandStopped:
+				t.started = time.Time{}
+			}
+			t.draw()
+		case <-winch:
+			t.resize()
+			t.draw()
+		case <-ticker.C:
+			// Redraw for new output and the running time
+			if _, changed := t.output.snapshot(); changed || !t.started.IsZero() {
+				t.draw()
+			}
+		}
+	}
+}
+
+// resize reads the terminal's size
+func (t *tui) resize() {
+	t.rows, t.cols = 24, 80
+	size, err := stty("size")
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(size)
+	if len(fields) != 2 {
+		return
+	}
+	if rows, err := strconv.Atoi(fields[0]); err == nil && rows > 0 {
+		t.rows = rows
+	}
+	if cols, err := strconv.Atoi(fields[1]); err == nil && cols > 0 {
+		t.cols = cols
+	}
+}
+
+// draw redraws the whole screen
+func (t *tui) draw() {
+	var screen strings.Builder
+	screen.WriteString(clearTUI)
+
+	state := "idle"
+	if !t.started.IsZero() {
+		state = fmt.Sprintf("running for %s", time.Since(t.started).Round(time.Second))
+	}
+	last := "none yet"
+	if t.exited {
+		color := succeedText
+		if t.lastCode != 0 {
+			color = failedText
+		}
+		last = fmt.Sprintf("%sexit %d%s in %s", color, t.lastCode, plainText, t.lastTook.Round(time.Millisecond))
+	}
+	stats := t.run.Stats()
+	header := []string{
+		boldText + t.truncate("rerun: "+t.command) + plainText,
+		fmt.Sprintf("Status: %s   Last run: %s   Runs: %d, %d failed", state, last, stats.Runs, stats.Failures),
+		t.truncate("r rerun   j/k scroll   G follow   q quit"),
+		strings.Repeat("─", t.cols),
+	}
+	for _, line := range header {
+		screen.WriteString(line + "\r\n")
+	}
+
+	// Show as much of the end of the output as fits, scrolled up by scroll
+	lines, _ := t.output.snapshot()
+	height := t.rows - len(header)
+	if height < 1 {
+		height = 1
+	}
+	if max := len(lines) - height; t.scroll > max {
+		t.scroll = max
+	}
+	if t.scroll < 0 {
+		t.scroll = 0
+	}
+	end := len(lines) - t.scroll
+	start := end - height
+	if start < 0 {
+		start = 0
+	}
+	for i, line := range lines[start:end] {
+		screen.WriteString(t.truncate(line))
+		if i < end-start-1 {
+			screen.WriteString("\r\n")
+		}
+	}
+	fmt.Print(screen.String())
+}
+
+// truncate cuts the line to the width of the terminal
+func (t *tui) truncate(line string) string {
+	line = strings.Replace(line, "\t", "    ", -1)
+	runes := []rune(line)
+	if len(runes) > t.cols {
+		return string(runes[:t.cols])
+	}
+	return line
+}
@@ -0,0 +1,28 @@
+//go:build windows || notui
+// +build windows notui
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+
+	"github.com/jeffxf/rerun"
+)
+
+// tuiSupported reports whether rerun was built with --tui
+const tuiSupported = false
+
+// tuiOutput discards the command's output when the TUI isn't built in
+type tuiOutput struct{}
+
+// Write implements io.Writer
+func (o *tuiOutput) Write(b []byte) (int, error) {
+	return ioutil.Discard.Write(b)
+}
+
+// runTUI always fails when the TUI isn't built in
+func runTUI(run *rerun.Rerun, output *tuiOutput, cancel context.CancelFunc) (func(), error) {
+	return nil, errors.New("--tui isn't supported by this build of rerun")
+}
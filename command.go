@@ -0,0 +1,248 @@
+package rerun
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// step is one of the commands run in order for each change
+type step struct {
+	// command is run with the shell unless argv is set
+	command string
+	argv    []string
+}
+
+// command returns the step's command to execute, either wrapped in the
+// configured shell or exec'd directly, with the placeholders for the changed
+// file expanded
+func (r *Rerun) command(step step, reason reason) *exec.Cmd {
+	if step.argv != nil {
+		argv := make([]string, len(step.argv))
+		for i, arg := range step.argv {
+			argv[i] = r.expand(arg, reason, false)
+		}
+		return exec.Command(argv[0], argv[1:]...)
+	}
+	shell := r.currentShell()
+	if shell == "" {
+		shell = DefaultShell
+	}
+	flag := shellFlag(shell)
+	// Only POSIX shells understand the quoting of the placeholders' values
+	return exec.Command(shell, flag, r.expand(step.command, reason, flag == "-c"))
+}
+
+// expand replaces {file}, {dir}, {base} and {ext} in the command with the most
+// recently changed file, relative to the directory the command runs in when
+// it's inside it. DefaultFile is used when nothing changed. The values are
+// quoted for a shell with quote, and shell variables such as ${file} are left
+// alone
+func (r *Rerun) expand(command string, reason reason, quote bool) string {
+	if !strings.Contains(command, "{") {
+		return command
+	}
+	file := r.DefaultFile
+	if len(reason.changed) > 0 {
+		file = reason.changed[len(reason.changed)-1]
+		dir := r.Dir
+		if dir == "" {
+			dir, _ = os.Getwd()
+		}
+		if rel, err := filepath.Rel(dir, file); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			file = rel
+		}
+	}
+	var dir, base, ext string
+	if file != "" {
+		dir = filepath.Dir(file)
+		base = filepath.Base(file)
+		ext = strings.TrimPrefix(filepath.Ext(base), base)
+	}
+	values := map[string]string{"{file}": file, "{dir}": dir, "{base}": base, "{ext}": ext}
+
+	var expanded strings.Builder
+	for i := 0; i < len(command); i++ {
+		if command[i] == '{' && (i == 0 || command[i-1] != '$') {
+			if end := strings.IndexByte(command[i:], '}'); end > 0 {
+				if value, ok := values[command[i:i+end+1]]; ok {
+					if quote {
+						value = quoteArg(value)
+					}
+					expanded.WriteString(value)
+					i += end
+					continue
+				}
+			}
+		}
+		expanded.WriteByte(command[i])
+	}
+	return expanded.String()
+}
+
+// quoteArgs joins the arguments into a command line for display, quoting any
+// which are empty or contain spaces, quotes or shell metacharacters
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteArg(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// quoteArg quotes the argument for a shell if it's empty or contains spaces,
+// quotes or shell metacharacters
+func quoteArg(arg string) string {
+	if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$`|&;<>()*?[]{}~#!") {
+		return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+	}
+	return arg
+}
+
+// Reasons for running the command, which are given to it in RERUN_EVENT
+const (
+	reasonInitial   = "initial"
+	reasonChange    = "change"
+	reasonKeepalive = "keepalive"
+	reasonManual    = "manual"
+)
+
+// reason describes why the command is being run
+type reason struct {
+	event   string
+	changed []string
+	// op is the operation of the most recent change
+	op fsnotify.Op
+}
+
+// environ returns the environment for the command, which is rerun's own
+// environment and the configured variables along with why the command is
+// being run
+func (r *Rerun) environ(reason reason) []string {
+	var last string
+	if len(reason.changed) > 0 {
+		last = reason.changed[len(reason.changed)-1]
+	}
+	env := append(os.Environ(), r.Env...)
+	return append(env,
+		"RERUN_EVENT="+reason.event,
+		"RERUN_OP="+opName(reason.op),
+		"RERUN_CHANGED_FILES="+strings.Join(reason.changed, "\n"),
+		"RERUN_CHANGED_FILE="+last,
+	)
+}
+
+// opName returns the name of the operation for RERUN_OP, empty when there's
+// no change
+func opName(op fsnotify.Op) string {
+	switch {
+	case op&fsnotify.Create != 0:
+		return "create"
+	case op&fsnotify.Write != 0:
+		return "write"
+	case op&fsnotify.Remove != 0:
+		return "remove"
+	case op&fsnotify.Rename != 0:
+		return "rename"
+	case op&fsnotify.Chmod != 0:
+		return "chmod"
+	}
+	return ""
+}
+
+// ops are the operations by the names used in RERUN_OP
+var ops = map[string]fsnotify.Op{
+	"create": fsnotify.Create,
+	"write":  fsnotify.Write,
+	"remove": fsnotify.Remove,
+	"rename": fsnotify.Rename,
+	"chmod":  fsnotify.Chmod,
+}
+
+// ParseOps returns the operations in a comma separated list such as
+// write,create
+func ParseOps(list string) (fsnotify.Op, error) {
+	var op fsnotify.Op
+	for _, name := range strings.Split(list, ",") {
+		o, ok := ops[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("Unknown operation %q", name)
+		}
+		op |= o
+	}
+	return op, nil
+}
+
+// shellFlag returns the flag the shell uses to run a command string
+func shellFlag(shell string) string {
+	name := strings.ToLower(strings.TrimSuffix(filepath.Base(shell), filepath.Ext(shell)))
+	switch name {
+	case "cmd":
+		return "/c"
+	case "powershell", "pwsh":
+		return "-Command"
+	}
+	return "-c"
+}
+
+// splitArgs splits a command into arguments on whitespace, honoring single
+// quotes, double quotes and backslash escapes like a shell would
+func splitArgs(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, c := range command {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote in command")
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash in command")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// ParseSignal returns the signal with the name, such as HUP or SIGHUP
+func ParseSignal(name string) (os.Signal, error) {
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return nil, fmt.Errorf("Unknown signal %q", name)
+	}
+	return sig, nil
+}
@@ -0,0 +1,152 @@
+package rerun
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQuoteArgs(t *testing.T) {
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"go", "test", "./..."}, "go test ./..."},
+		{[]string{"echo", "hello world"}, "echo 'hello world'"},
+		{[]string{"echo", ""}, "echo ''"},
+		{[]string{"echo", "it's"}, `echo 'it'\''s'`},
+		{[]string{"sh", "-c", "a | b && c"}, "sh -c 'a | b && c'"},
+		{[]string{"echo", "$HOME", "*.go"}, "echo '$HOME' '*.go'"},
+		{[]string{"echo", `"quoted"`}, `echo '"quoted"'`},
+	} {
+		if got := quoteArgs(test.args); got != test.want {
+			t.Errorf("quoteArgs(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	for _, test := range []struct {
+		command string
+		want    []string
+	}{
+		{"go test ./...", []string{"go", "test", "./..."}},
+		{"  echo \t hi\n", []string{"echo", "hi"}},
+		{"echo 'hello world'", []string{"echo", "hello world"}},
+		{`echo "hello world"`, []string{"echo", "hello world"}},
+		{`echo hello\ world`, []string{"echo", "hello world"}},
+		{`echo 'a\b'`, []string{"echo", `a\b`}},
+		{`echo "a\"b"`, []string{"echo", `a"b`}},
+		{`echo 'it'\''s'`, []string{"echo", "it's"}},
+		{"echo ''", []string{"echo", ""}},
+		{"echo a'b'c", []string{"echo", "abc"}},
+		{"sh -c 'a | b'", []string{"sh", "-c", "a | b"}},
+	} {
+		got, err := splitArgs(test.command)
+		if err != nil {
+			t.Errorf("splitArgs(%q) failed: %v", test.command, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", test.command, got, test.want)
+		}
+	}
+	for _, command := range []string{"echo 'hi", `echo "hi`, `echo hi\`} {
+		if _, err := splitArgs(command); err == nil {
+			t.Errorf("splitArgs(%q) succeeded, want an error", command)
+		}
+	}
+}
+
+func TestSplitQuotedArgs(t *testing.T) {
+	// Quoting the arguments for display then splitting them gives them back
+	// byte for byte
+	for _, args := range [][]string{
+		{"sh", "-c", "complex | pipeline > out"},
+		{"echo", "it's", `"both" 'quotes'`},
+		{"echo", "", "  spaces  ", "tab\there"},
+		{"printf", `%s\n`, "$HOME", "*.go", "a;b", "~user", "#not a comment"},
+	} {
+		got, err := splitArgs(quoteArgs(args))
+		if err != nil {
+			t.Errorf("splitArgs(quoteArgs(%q)) failed: %v", args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, args) {
+			t.Errorf("splitArgs(quoteArgs(%q)) = %q", args, got)
+		}
+	}
+}
+
+func TestArgsRunUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	args := []string{"a b", "it's", `"quoted"`, "$HOME", "*", "a|b;c", ""}
+	config := testConfig(t, dir, "")
+	config.Args = append([]string{"sh", "-c", `printf '%s\n' "$@" > out`, "sh"}, args...)
+	code, err := RunOnce(config)
+	if err != nil || code != 0 {
+		t.Fatalf("RunOnce() = %d, %v", code, err)
+	}
+	out, err := ioutil.ReadFile(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n"); !reflect.DeepEqual(got, args) {
+		t.Errorf("Command got the arguments %q, want %q", got, args)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	dir := t.TempDir()
+	r := newTestRerun(t, testConfig(t, dir, "true"))
+	changed := func(name string) reason {
+		return reason{event: reasonChange, changed: []string{filepath.Join(dir, name)}}
+	}
+	for _, test := range []struct {
+		command string
+		reason  reason
+		quote   bool
+		want    string
+	}{
+		{"go test ./{dir}/...", changed("pkg/main.go"), true, "go test ./pkg/..."},
+		{"echo {file} {dir} {base} {ext}", changed("pkg/main.go"), true, "echo pkg/main.go pkg main.go .go"},
+		{"cat {file}", changed("a b.go"), true, "cat 'a b.go'"},
+		{"cat {file}", changed("a;rm -rf x.go"), true, "cat 'a;rm -rf x.go'"},
+		{"cat {file}", changed("$(touch x).go"), true, "cat '$(touch x).go'"},
+		{"cat {base}", changed("it's.go"), true, `cat 'it'\''s.go'`},
+		{"cat {file}", changed("a b.go"), false, "cat a b.go"},
+		// Shell variables aren't placeholders
+		{"dir=x; echo ${dir} ${file}", changed("main.go"), true, "dir=x; echo ${dir} ${file}"},
+		{"dir=x; echo ${dir}/{base}", reason{event: reasonInitial}, true, "dir=x; echo ${dir}/''"},
+		{"echo {other} {", changed("main.go"), true, "echo {other} {"},
+	} {
+		if got := r.expand(test.command, test.reason, test.quote); got != test.want {
+			t.Errorf("expand(%q) = %q, want %q", test.command, got, test.want)
+		}
+	}
+}
+
+func TestPlaceholdersRunQuoted(t *testing.T) {
+	dir := t.TempDir()
+	r := newTestRerun(t, testConfig(t, dir, `printf '%s\n' {file} "${dir:-unset}"`))
+
+	for _, name := range []string{"a b.go", "a;touch pwned.go", "$(touch pwned).go", "it's `x`.go"} {
+		cmd := r.command(r.steps[0], reason{event: reasonChange, changed: []string{filepath.Join(dir, name)}})
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("Command for %q failed: %v", name, err)
+		}
+		if want := name + "\nunset\n"; string(out) != want {
+			t.Errorf("Command for %q printed %q, want %q", name, out, want)
+		}
+	}
+	for _, name := range []string{"pwned", "pwned.go"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("The changed file's name was run as a command, creating %q", name)
+		}
+	}
+}
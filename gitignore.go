@@ -0,0 +1,187 @@
+package rerun
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// gitignorePattern is a single pattern from a .gitignore file
+type gitignorePattern struct {
+	segments []string // Pattern split on "/"
+	negate   bool     // Pattern started with "!"
+	dirOnly  bool     // Pattern ended with "/"
+	anchored bool     // Pattern is relative to the .gitignore's directory
+}
+
+// parseGitignore returns the patterns in the contents of a .gitignore file
+func parseGitignore(data string) []gitignorePattern {
+	var patterns []gitignorePattern
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		// Trailing spaces are ignored unless they're escaped
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var p gitignorePattern
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// A slash anywhere but the end anchors the pattern to the directory
+		// of the .gitignore, otherwise it matches a name at any depth
+		if strings.Contains(line, "/") {
+			p.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		p.segments = strings.Split(line, "/")
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// match reports whether the slash separated path relative to the .gitignore's
+// directory matches the pattern
+func (p gitignorePattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	names := strings.Split(rel, "/")
+	if !p.anchored {
+		ok, _ := path.Match(p.segments[0], names[len(names)-1])
+		return ok
+	}
+	return matchSegments(p.segments, names)
+}
+
+// matchSegments matches path segments against glob pattern segments where a
+// "**" segment matches zero or more path segments
+func matchSegments(pattern, names []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			// A trailing "**" matches everything inside the directory
+			if len(pattern) == 0 {
+				return len(names) > 0
+			}
+			for i := range names {
+				if matchSegments(pattern, names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], names[0]); !ok {
+			return false
+		}
+		pattern, names = pattern[1:], names[1:]
+	}
+	return len(names) == 0
+}
+
+// loadGitignore reads the .gitignore file in dir, if there is one
+func (r *Rerun) loadGitignore(dir string) {
+	if r.NoGitignore {
+		return
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		delete(r.gitignores, dir)
+		return
+	}
+	r.gitignores[dir] = parseGitignore(string(data))
+	log.Debugf("Loaded %d patterns from %q", len(r.gitignores[dir]), filepath.Join(dir, ".gitignore"))
+}
+
+// loadParentGitignores reads the .gitignore files between the top of the git
+// repository containing root and root itself
+func (r *Rerun) loadParentGitignores(root string) {
+	var parents []string
+	for dir := filepath.Dir(root); ; dir = filepath.Dir(dir) {
+		parents = append(parents, dir)
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			break
+		}
+		// Root isn't inside a git repository
+		if dir == filepath.Dir(dir) {
+			return
+		}
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		r.loadGitignore(parents[i])
+	}
+}
+
+// gitignored reports whether the path, or any of its parent directories below
+// the watched root, is ignored by a .gitignore file
+func (r *Rerun) gitignored(path string, isDir bool) bool {
+	if r.NoGitignore || len(r.gitignores) == 0 {
+		return false
+	}
+	root := r.root(path)
+	if root == "" || root == path {
+		return r.gitignoredPath(path, isDir)
+	}
+	// Git doesn't look inside ignored directories so their contents are
+	// ignored too
+	rel := r.relPath(path)
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i := range parts {
+		prefix := filepath.Join(root, filepath.Join(parts[:i+1]...))
+		if r.gitignoredPath(prefix, isDir || i < len(parts)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitignoredPath reports whether the patterns in the .gitignore files of the
+// path's parent directories ignore it. Patterns in deeper files take
+// precedence and the last matching pattern in a file wins.
+func (r *Rerun) gitignoredPath(path string, isDir bool) bool {
+	var dirs []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		patterns, ok := r.gitignores[dirs[i]]
+		if !ok {
+			continue
+		}
+		rel, err := filepath.Rel(dirs[i], path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, p := range patterns {
+			if p.match(rel, isDir) {
+				ignored = !p.negate
+			}
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,113 @@
+package rerun
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// matchGlob reports whether the slash separated path matches the pattern,
+// where ** matches any number of directories
+func matchGlob(pattern, path string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(path, "/"))
+}
+
+// globCouldContain reports whether paths inside the slash separated directory
+// could match the pattern
+func globCouldContain(pattern, dir string) bool {
+	patternSegments := strings.Split(pattern, "/")
+	for _, segment := range strings.Split(dir, "/") {
+		if len(patternSegments) == 0 {
+			return false
+		}
+		if patternSegments[0] == "**" {
+			return true
+		}
+		if ok, _ := filepath.Match(patternSegments[0], segment); !ok {
+			return false
+		}
+		patternSegments = patternSegments[1:]
+	}
+	return len(patternSegments) > 0
+}
+
+// globRoot returns the nearest existing directory above the first segment of
+// the absolute pattern containing a wildcard
+func globRoot(pattern string) string {
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+	for i, segment := range segments {
+		if strings.ContainsAny(segment, `*?[\`) {
+			segments = segments[:i]
+			break
+		}
+	}
+	dir := filepath.FromSlash(strings.Join(segments, "/"))
+	if dir == "" {
+		dir = string(filepath.Separator)
+	}
+	for {
+		info, err := os.Stat(dir)
+		if err == nil && info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// resolvePatterns makes each of the Watch patterns absolute and watches the
+// nearest existing directory to each which isn't already watched
+func (r *Rerun) resolvePatterns() error {
+	for _, pattern := range r.Watch {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid pattern %q", pattern)
+		}
+		path, err := filepath.Abs(pattern)
+		if err != nil {
+			return fmt.Errorf("Unable to determine absolute path of %q: %q", pattern, err)
+		}
+		r.patterns = append(r.patterns, filepath.ToSlash(path))
+		root := globRoot(path)
+		if r.root(root) == "" {
+			log.WithField("path", root).Debugf("Watching %q for paths matching %q", root, pattern)
+			r.roots = append(r.roots, root)
+			r.patternRoots[root] = true
+		}
+	}
+	return nil
+}
+
+// patternRoot reports whether the path is in a directory which is only
+// watched for the Watch patterns
+func (r *Rerun) patternRoot(path string) bool {
+	return r.patternRoots[r.root(path)]
+}
+
+// matchesPattern reports whether the path matches one of the Watch patterns
+func (r *Rerun) matchesPattern(path string) bool {
+	path = filepath.ToSlash(path)
+	for _, pattern := range r.patterns {
+		if matchGlob(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// patternCouldContain reports whether paths in the directory could match one
+// of the Watch patterns
+func (r *Rerun) patternCouldContain(dir string) bool {
+	dir = filepath.ToSlash(dir)
+	for _, pattern := range r.patterns {
+		if globCouldContain(pattern, dir) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,15 @@
 module github.com/jeffxf/rerun
 
-go 1.14
+go 1.20
 
 require (
 	github.com/fsnotify/fsnotify v1.4.9
 	github.com/matryer/runner v0.0.0-20190427160343-b472a46105b1
 	github.com/sirupsen/logrus v1.6.0
+	gopkg.in/yaml.v2 v2.3.0
+)
+
+require (
+	github.com/konsorten/go-windows-terminal-sequences v1.0.3 // indirect
+	golang.org/x/sys v0.0.0-20191005200804-aed5e4c7ecf9 // indirect
 )
@@ -0,0 +1,102 @@
+package rerun
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultHookTimeout is how long OnSuccess and OnFailure may run before
+// they're killed
+const DefaultHookTimeout = 30 * time.Second
+
+// runHook runs OnSuccess or OnFailure for a run which exited with the code
+func (r *Rerun) runHook(ctx context.Context, code int, reason reason) {
+	hook := r.OnSuccess
+	if code != 0 {
+		hook = r.OnFailure
+	}
+	if hook == "" {
+		return
+	}
+	r.execHook(ctx, hook, reason, fmt.Sprintf("RERUN_EXIT_CODE=%d", code))
+}
+
+// runReadyHook runs OnReady in the background the first time the command is
+// ready, which is once it's accepting connections with WaitFor or otherwise
+// the first time it exits with status 0
+func (r *Rerun) runReadyHook(reason reason) {
+	if r.OnReady == "" {
+		return
+	}
+	r.mu.Lock()
+	fired := r.readyFired
+	r.readyFired = true
+	r.mu.Unlock()
+	if fired {
+		return
+	}
+	// Restarts of the command don't stop it, only rerun exiting
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-r.interrupted:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	go func() {
+		defer cancel()
+		r.execHook(ctx, r.OnReady, reason, "RERUN_EXIT_CODE=0")
+	}()
+}
+
+// execHook runs the hook with the command's environment and the extra
+// variables, killing it if it takes longer than HookTimeout or the context is
+// cancelled
+func (r *Rerun) execHook(ctx context.Context, hook string, reason reason, env ...string) {
+	timeout := r.HookTimeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	fields := log.Fields{"command": hook}
+
+	cmd := r.command(step{command: hook}, reason)
+	cmd.Env = append(r.environ(reason), env...)
+	cmd.Dir = r.Dir
+	cmd.Stdout = r.stdoutLine
+	cmd.Stderr = r.stderrLine
+	setProcessGroup(cmd)
+	r.credential.set(cmd)
+	log.WithFields(fields).Debug("Running hook")
+	err := cmd.Start()
+	if err != nil {
+		log.WithFields(fields).Errorf("Unable to run hook: %q", err)
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err = <-done:
+	case <-timer.C:
+		log.WithFields(fields).Warnf("Hook didn't finish within %s and was killed", timeout)
+		kill(cmd)
+		<-done
+		return
+	case <-ctx.Done():
+		log.WithFields(fields).Debug("Killing hook since the command or rerun was stopped")
+		kill(cmd)
+		<-done
+		return
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"command": hook, "exit_code": exitCode(err)}).Warnf("Hook exited with status %d", exitCode(err))
+	}
+}
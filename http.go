@@ -0,0 +1,120 @@
+package rerun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// httpShutdownTimeout is how long requests are given to finish when rerun
+// exits
+const httpShutdownTimeout = 2 * time.Second
+
+// status is the response for GET /status
+type status struct {
+	Command      string `json:"command"`
+	Running      bool   `json:"running"`
+	LastExitCode int    `json:"last_exit_code"`
+	Runs         int    `json:"runs"`
+	Successes    int    `json:"successes"`
+	Failures     int    `json:"failures"`
+	// AverageDuration is in seconds
+	AverageDuration float64  `json:"average_duration"`
+	WatchedDirs     []string `json:"watched_dirs"`
+}
+
+// output is the response for GET /logs
+type output struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+}
+
+// serveHTTP starts the HTTP control server on HTTPAddr
+func (r *Rerun) serveHTTP() error {
+	listener, err := net.Listen("tcp", r.HTTPAddr)
+	if err != nil {
+		return fmt.Errorf("Unable to listen on %s: %q", r.HTTPAddr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rerun", r.handleRerun)
+	mux.HandleFunc("/status", r.handleStatus)
+	mux.HandleFunc("/logs", r.handleLogs)
+	r.server = &http.Server{Handler: mux}
+
+	log.Debugf("Serving HTTP on %s", listener.Addr())
+	go func() {
+		err := r.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("HTTP server error: %q", err)
+		}
+	}()
+	return nil
+}
+
+// closeHTTP shuts down the HTTP control server if it's running
+func (r *Rerun) closeHTTP() {
+	if r.server == nil {
+		return
+	}
+	log.Debug("Stopping the HTTP server")
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	r.server.Shutdown(ctx)
+}
+
+// handleRerun restarts the command for POST /rerun
+func (r *Rerun) handleRerun(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	log.Info("Rerunning command since a rerun was requested over HTTP")
+	r.requestRerun()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleStatus returns the command's state for GET /status
+func (r *Rerun) handleStatus(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	stats := r.Stats()
+	writeJSON(w, status{
+		Command:         r.currentCommand(),
+		Running:         r.Running(),
+		LastExitCode:    r.LastExitCode(),
+		Runs:            stats.Runs,
+		Successes:       stats.Successes,
+		Failures:        stats.Failures,
+		AverageDuration: stats.Average().Seconds(),
+		WatchedDirs:     r.WatchedDirs(),
+	})
+}
+
+// handleLogs returns the current or most recent run's output for GET /logs
+func (r *Rerun) handleLogs(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	stdout, stderr := r.LastOutput()
+	writeJSON(w, output{Stdout: stdout, Stderr: stderr})
+}
+
+// writeJSON writes the value as a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(v)
+	if err != nil {
+		log.Debugf("Unable to write HTTP response: %q", err)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package rerun
+
+import (
+	"os"
+	"syscall"
+)
+
+// inode identifies a directory by its device and inode numbers
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// dirKey returns a key which is the same for every path to the directory,
+// however many symlinks lead to it
+func dirKey(path string, f os.FileInfo) (interface{}, bool) {
+	stat, ok := f.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, false
+	}
+	return inode{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
@@ -0,0 +1,17 @@
+package rerun
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// dirKey returns a key which is the same for every path to the directory,
+// which is its real path since os.FileInfo doesn't carry a file index on
+// Windows
+func dirKey(path string, f os.FileInfo) (interface{}, bool) {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, false
+	}
+	return real, true
+}
@@ -0,0 +1,114 @@
+package rerun
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LifecycleType identifies what happened in a LifecycleEvent
+type LifecycleType string
+
+const (
+	// CommandStarted is sent when a new execution of the command has started
+	CommandStarted LifecycleType = "started"
+	// CommandReady is sent once the command is accepting connections on
+	// WaitFor
+	CommandReady LifecycleType = "ready"
+	// CommandExited is sent when the command exits on its own
+	CommandExited LifecycleType = "exited"
+	// CommandStopped is sent when the command was stopped by rerun
+	CommandStopped LifecycleType = "stopped"
+	// FileChanged is sent when a change to a watched path triggers a rerun
+	FileChanged LifecycleType = "changed"
+	// Error is sent when the command couldn't be started or the filesystem
+	// watcher fails
+	Error LifecycleType = "error"
+)
+
+// LifecycleEvent describes a change in the state of a Rerun
+type LifecycleEvent struct {
+	Type     LifecycleType
+	Time     time.Time
+	Command  string
+	Path     string // Set for FileChanged
+	ExitCode int    // Set for CommandExited
+	// Duration is how long the run took, set for CommandExited
+	Duration time.Duration
+	Error    string // Set for Error
+	// Reason is why rerun stopped the command, set for CommandStopped and for
+	// CommandExited when it timed out
+	Reason string
+}
+
+// Causes of rerun stopping the command, which are the LifecycleEvent's Reason
+var (
+	ErrFilesChanged = errors.New("files changed")
+	ErrRestarted    = errors.New("a restart was requested")
+	ErrReloaded     = errors.New("the config was reloaded")
+	ErrStopped      = errors.New("it was stopped")
+	ErrShutdown     = errors.New("rerun is exiting")
+	ErrTimedOut     = errors.New("it timed out")
+)
+
+// stopCause returns why the command is stopped to rerun it for the reason
+func stopCause(reason reason) error {
+	if reason.event == reasonChange {
+		return ErrFilesChanged
+	}
+	return ErrRestarted
+}
+
+// lifecycleBuffer is how many lifecycle events are buffered before new events
+// are dropped
+const lifecycleBuffer = 64
+
+// Lifecycle returns a channel of lifecycle events. Events are dropped if the
+// channel isn't read from fast enough.
+func (r *Rerun) Lifecycle() <-chan LifecycleEvent {
+	return r.lifecycle
+}
+
+// emit sends a lifecycle event to Lifecycle() and each subscriber without
+// blocking
+func (r *Rerun) emit(event LifecycleEvent) {
+	event.Time = time.Now()
+	event.Command = r.currentCommand()
+	select {
+	case r.lifecycle <- event:
+	default:
+		log.Debugf("Dropped %s lifecycle event", event.Type)
+	}
+	r.subscribersMu.Lock()
+	defer r.subscribersMu.Unlock()
+	for _, subscriber := range r.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			log.Debugf("Dropped %s lifecycle event for a subscriber", event.Type)
+		}
+	}
+}
+
+// subscribe returns a new channel of lifecycle events for another consumer
+// than Lifecycle(), such as the event socket
+func (r *Rerun) subscribe() chan LifecycleEvent {
+	subscriber := make(chan LifecycleEvent, lifecycleBuffer)
+	r.subscribersMu.Lock()
+	r.subscribers = append(r.subscribers, subscriber)
+	r.subscribersMu.Unlock()
+	return subscriber
+}
+
+// unsubscribe stops sending lifecycle events to the subscriber
+func (r *Rerun) unsubscribe(subscriber chan LifecycleEvent) {
+	r.subscribersMu.Lock()
+	defer r.subscribersMu.Unlock()
+	for i, s := range r.subscribers {
+		if s == subscriber {
+			r.subscribers = append(r.subscribers[:i], r.subscribers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package rerun
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStopCauses(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		watch bool
+		stop  func(t *testing.T, r *Rerun, dir string)
+		want  error
+	}{
+		{"stopped", false, func(t *testing.T, r *Rerun, dir string) {
+			r.Stop()
+		}, ErrStopped},
+		{"restarted", false, func(t *testing.T, r *Rerun, dir string) {
+			r.Restart()
+		}, ErrRestarted},
+		{"shutdown", false, func(t *testing.T, r *Rerun, dir string) {
+			r.cleanup()
+		}, ErrShutdown},
+		{"files changed", true, func(t *testing.T, r *Rerun, dir string) {
+			writeFile(t, dir, "file.txt")
+		}, ErrFilesChanged},
+		{"reloaded", true, func(t *testing.T, r *Rerun, dir string) {
+			config := r.Config
+			config.Command = "sleep 101"
+			if err := r.Reload(config); err != nil {
+				t.Fatal(err)
+			}
+		}, ErrReloaded},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			r := newTestRerun(t, testConfig(t, dir, "sleep 100"))
+			if test.watch {
+				runRerun(t, r)
+			} else {
+				r.Start()
+			}
+			waitEvent(t, r, CommandStarted)
+
+			test.stop(t, r, dir)
+			if stopped := waitEvent(t, r, CommandStopped); stopped.Reason != test.want.Error() {
+				t.Errorf("Stopped since %q, want %q", stopped.Reason, test.want)
+			}
+		})
+	}
+}
+
+func TestTimeoutCause(t *testing.T) {
+	dir := t.TempDir()
+	config := testConfig(t, dir, "sleep 100")
+	config.Timeout = 100 * time.Millisecond
+	r := newTestRerun(t, config)
+
+	r.Start()
+	exited := waitEvent(t, r, CommandExited)
+	if exited.Reason != ErrTimedOut.Error() {
+		t.Errorf("Exited since %q, want %q", exited.Reason, ErrTimedOut)
+	}
+	if exited.ExitCode == 0 {
+		t.Error("Timed out run passed")
+	}
+}
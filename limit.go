@@ -0,0 +1,49 @@
+package rerun
+
+import "time"
+
+// DefaultRestartInterval is the window MaxRestarts applies to when
+// RestartInterval isn't set
+const DefaultRestartInterval = 10 * time.Second
+
+// restartLimit counts restarts within a sliding window
+type restartLimit struct {
+	max      int
+	interval time.Duration
+	times    []time.Time
+}
+
+// newRestartLimit returns a limit of max restarts within the interval, which
+// allows every restart when max isn't positive
+func newRestartLimit(max int, interval time.Duration) *restartLimit {
+	if interval <= 0 {
+		interval = DefaultRestartInterval
+	}
+	return &restartLimit{max: max, interval: interval}
+}
+
+// allow records a restart at now, reporting whether it's within the limit
+func (l *restartLimit) allow(now time.Time) bool {
+	if l.max <= 0 {
+		return true
+	}
+
+	// Forget restarts which have left the window
+	cutoff := now.Add(-l.interval)
+	i := 0
+	for i < len(l.times) && !l.times[i].After(cutoff) {
+		i++
+	}
+	l.times = l.times[i:]
+
+	if len(l.times) >= l.max {
+		return false
+	}
+	l.times = append(l.times, now)
+	return true
+}
+
+// reset forgets every restart
+func (l *restartLimit) reset() {
+	l.times = nil
+}
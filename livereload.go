@@ -0,0 +1,136 @@
+package rerun
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultServeAddr is the address the live reload proxy listens on
+const DefaultServeAddr = "localhost:35729"
+
+// reloadPath is where pages served by the proxy listen for reloads
+const reloadPath = "/__rerun/reload"
+
+// reloadScript is injected into HTML pages to reload them after each
+// successful run
+const reloadScript = `<script>new EventSource("` + reloadPath + `").onmessage = function() { location.reload() }</script>`
+
+// serveProxy starts the live reload proxy for Serve on ServeAddr
+func (r *Rerun) serveProxy() error {
+	target, err := url.Parse(r.Serve)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return fmt.Errorf("Unable to proxy to %q: expected a URL such as http://localhost:3000", r.Serve)
+	}
+	addr := r.ServeAddr
+	if addr == "" {
+		addr = DefaultServeAddr
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Unable to listen on %s: %q", addr, err)
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		req.Host = target.Host
+		// Compressed pages can't have the script injected
+		req.Header.Del("Accept-Encoding")
+	}
+	proxy.ModifyResponse = injectReloadScript
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(reloadPath, r.handleReload)
+	mux.Handle("/", proxy)
+	r.proxy = &http.Server{Handler: mux}
+
+	log.WithField("addr", listener.Addr().String()).Infof("Serving %s with live reload on http://%s", r.Serve, listener.Addr())
+	go func() {
+		err := r.proxy.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("Live reload proxy error: %q", err)
+		}
+	}()
+	return nil
+}
+
+// closeProxy shuts down the live reload proxy if it's running
+func (r *Rerun) closeProxy() {
+	if r.proxy == nil {
+		return
+	}
+	log.Debug("Stopping the live reload proxy")
+	// Reload streams never end on their own, so don't wait for them
+	r.proxy.Close()
+}
+
+// injectReloadScript adds the reload script to the end of HTML pages
+func injectReloadScript(resp *http.Response) error {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if i := bytes.LastIndex(body, []byte("</body>")); i >= 0 {
+		body = append(body[:i], append([]byte(reloadScript), body[i:]...)...)
+	} else {
+		body = append(body, reloadScript...)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// handleReload streams a server-sent event to the page each time a run
+// succeeds, or once the command is ready with WaitFor
+func (r *Rerun) handleReload(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	events := r.subscribe()
+	defer r.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case event := <-events:
+			if !r.reloads(event) {
+				continue
+			}
+			log.Debug("Reloading pages served by the live reload proxy")
+			fmt.Fprint(w, "data: reload\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// reloads reports whether the lifecycle event should reload the page, which
+// it does once the command is ready with WaitFor, or otherwise after it exits
+// successfully
+func (r *Rerun) reloads(event LifecycleEvent) bool {
+	if r.WaitFor != "" {
+		return event.Type == CommandReady
+	}
+	return event.Type == CommandExited && event.ExitCode == 0
+}
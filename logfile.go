@@ -0,0 +1,100 @@
+package rerun
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// logFile appends the command's output to a file, moving it to a .1 backup
+// once it would grow past maxSize
+type logFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+}
+
+// openLogFile opens the LogFile to append the command's output to
+func (r *Rerun) openLogFile() error {
+	if r.LogFile == "" {
+		return nil
+	}
+	l := &logFile{path: r.LogFile, maxSize: r.LogFileMaxSize}
+	err := l.open()
+	if err != nil {
+		return err
+	}
+	r.logFile = l
+	return nil
+}
+
+// closeLogFile closes the LogFile if it's open
+func (r *Rerun) closeLogFile() {
+	if r.logFile != nil {
+		r.logFile.close()
+	}
+}
+
+// open opens the file for appending
+func (l *logFile) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to open log file: %q", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("Unable to open log file: %q", err)
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// Write implements io.Writer
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return len(p), nil
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		l.rotate()
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	if err != nil {
+		// Don't let a full disk stop the command's output reaching the
+		// terminal
+		log.Debugf("Unable to write to log file: %q", err)
+	}
+	return len(p), nil
+}
+
+// rotate replaces the backup with the current file and starts a new one,
+// l.mu must be held
+func (l *logFile) rotate() {
+	l.file.Close()
+	l.file = nil
+	err := os.Rename(l.path, l.path+".1")
+	if err != nil {
+		log.Debugf("Unable to rotate log file: %q", err)
+	}
+	err = l.open()
+	if err != nil {
+		log.Errorf("%s, so the command's output is no longer logged", err)
+	}
+}
+
+// close closes the file
+func (l *logFile) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
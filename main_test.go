@@ -0,0 +1,454 @@
+package rerun
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// eventTimeout is how long to wait for a lifecycle event before failing
+const eventTimeout = 5 * time.Second
+
+// quietPeriod is how long to wait to be sure no other run is coming
+const quietPeriod = 500 * time.Millisecond
+
+// syncBuffer is a bytes.Buffer which the command's output can be written to
+// while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// testConfig returns a config running the command with sh in and watching
+// dir, dropping the command's output. The test is skipped without sh, which
+// also can't be stopped along with its children on Windows
+func testConfig(t testing.TB, dir, command string) Config {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil || runtime.GOOS == "windows" {
+		t.Skip("The tests need sh to run their commands")
+	}
+	return Config{
+		Command: command,
+		Shell:   "sh",
+		Dir:     dir,
+		Dirs:    []string{dir},
+		Stdout:  ioutil.Discard,
+		Stderr:  ioutil.Discard,
+	}
+}
+
+// newTestRerun creates a Rerun for the config which is cleaned up once the
+// test finishes
+func newTestRerun(t testing.TB, config Config) *Rerun {
+	t.Helper()
+	r, err := NewRerun(config)
+	if err != nil {
+		t.Fatalf("NewRerun() failed: %v", err)
+	}
+	t.Cleanup(r.cleanup)
+	return r
+}
+
+// mkdirs creates the directories, relative to dir
+func mkdirs(t testing.TB, dir string, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		if err := os.MkdirAll(filepath.Join(dir, path), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeFile writes to the file, relative to dir
+func writeFile(t testing.TB, dir, path string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, path), []byte("change\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runRerun runs the main loop until the test finishes, checking it returns
+// once its context is cancelled
+func runRerun(t *testing.T, r *Rerun) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Run() failed: %v", err)
+			}
+		case <-time.After(eventTimeout):
+			t.Error("Run() didn't return after its context was cancelled")
+		}
+	})
+}
+
+// waitEvent waits for the next lifecycle event of the type, skipping others
+func waitEvent(t *testing.T, r *Rerun, typ LifecycleType) LifecycleEvent {
+	t.Helper()
+	timeout := time.After(eventTimeout)
+	for {
+		select {
+		case event := <-r.Lifecycle():
+			if event.Type == typ {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for a %s lifecycle event", typ)
+		}
+	}
+}
+
+// noEvent checks there's no lifecycle event of the type for quietPeriod
+func noEvent(t *testing.T, r *Rerun, typ LifecycleType) {
+	t.Helper()
+	timeout := time.After(quietPeriod)
+	for {
+		select {
+		case event := <-r.Lifecycle():
+			if event.Type == typ {
+				t.Fatalf("Unexpected %s lifecycle event: %+v", typ, event)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// waitRun waits for a run of the command to start and exit
+func waitRun(t *testing.T, r *Rerun) {
+	t.Helper()
+	waitEvent(t, r, CommandStarted)
+	waitEvent(t, r, CommandExited)
+}
+
+// watching reports whether the directory is in the filesystem watcher
+func watching(r *Rerun, dir string) bool {
+	return contains(r.WatchedDirs(), dir)
+}
+
+func TestNewRerunWatchesSubdirectories(t *testing.T) {
+	dir := t.TempDir()
+	mkdirs(t, dir, "a/b", "c", ".git/objects")
+	r := newTestRerun(t, testConfig(t, dir, "true"))
+
+	for _, path := range []string{"", "a", "a/b", "c"} {
+		if !watching(r, filepath.Join(dir, path)) {
+			t.Errorf("%q isn't watched, watching %q", path, r.WatchedDirs())
+		}
+	}
+	for _, path := range []string{".git", ".git/objects"} {
+		if watching(r, filepath.Join(dir, path)) {
+			t.Errorf("%q is watched", path)
+		}
+	}
+}
+
+func TestWatchDirSkipsGit(t *testing.T) {
+	dir := t.TempDir()
+	mkdirs(t, dir, ".git", "src")
+	r := newTestRerun(t, testConfig(t, dir, "true"))
+
+	for _, test := range []struct {
+		path string
+		want error
+	}{
+		{".git", filepath.SkipDir},
+		{"src", nil},
+	} {
+		path := filepath.Join(dir, test.path)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.WatchDir(path, info, nil); err != test.want {
+			t.Errorf("WatchDir(%q) = %v, want %v", test.path, err, test.want)
+		}
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	dir := t.TempDir()
+	r := newTestRerun(t, testConfig(t, dir, "echo hi > out"))
+
+	r.Start()
+	waitRun(t, r)
+	r.Stop()
+	out, err := ioutil.ReadFile(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatalf("Command didn't run: %v", err)
+	}
+	if string(out) != "hi\n" {
+		t.Errorf("Command wrote %q, want %q", out, "hi\n")
+	}
+	if stats := r.Stats(); stats.Runs != 1 || stats.Successes != 1 {
+		t.Errorf("Stats() = %+v, want one successful run", stats)
+	}
+}
+
+func TestStopKillsCommand(t *testing.T) {
+	dir := t.TempDir()
+	r := newTestRerun(t, testConfig(t, dir, "sleep 100"))
+
+	r.Start()
+	waitEvent(t, r, CommandStarted)
+	stopped := make(chan struct{})
+	go func() {
+		r.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(eventTimeout):
+		t.Fatal("Stop() didn't return")
+	}
+	if r.Running() {
+		t.Error("Command is still running after Stop()")
+	}
+}
+
+func TestRerunsOnFileWrite(t *testing.T) {
+	dir := t.TempDir()
+	mkdirs(t, dir, "sub")
+	r := newTestRerun(t, testConfig(t, dir, "true"))
+	runRerun(t, r)
+	waitRun(t, r)
+
+	writeFile(t, dir, "sub/file.txt")
+	changed := waitEvent(t, r, FileChanged)
+	if want := filepath.Join(dir, "sub/file.txt"); changed.Path != want {
+		t.Errorf("Changed path = %q, want %q", changed.Path, want)
+	}
+	waitRun(t, r)
+}
+
+func TestDuplicateEventsRerunOnce(t *testing.T) {
+	dir := t.TempDir()
+	r := newTestRerun(t, testConfig(t, dir, "true"))
+	runRerun(t, r)
+	waitRun(t, r)
+
+	// Each write sends its own event for the same path within
+	// duplicateWindow
+	for i := 0; i < 5; i++ {
+		writeFile(t, dir, "file.txt")
+	}
+	waitRun(t, r)
+	noEvent(t, r, CommandStarted)
+	if runs := r.Stats().Runs; runs != 2 {
+		t.Errorf("Command ran %d times, want 2", runs)
+	}
+}
+
+func TestStopAndCleanupTwice(t *testing.T) {
+	dir := t.TempDir()
+	r := newTestRerun(t, testConfig(t, dir, "sleep 100"))
+
+	r.Start()
+	waitEvent(t, r, CommandStarted)
+	r.Stop()
+	r.Stop()
+	r.cleanup()
+	r.cleanup()
+	if r.Running() {
+		t.Error("Command is still running after Stop()")
+	}
+	// Nothing starts once rerun has cleaned up
+	r.Start()
+	noEvent(t, r, CommandStarted)
+}
+
+func TestChangesWhileRestartingRerunOnce(t *testing.T) {
+	dir := t.TempDir()
+	mkdirs(t, dir, "src")
+	// The command takes a while to stop, leaving changes to queue up
+	config := testConfig(t, dir, "trap 'touch stopping; sleep 0.3; exit' TERM; while true; do sleep 0.05; done")
+	config.Dirs = []string{filepath.Join(dir, "src")}
+	config.Debounce = 100 * time.Millisecond
+	config.Grace = eventTimeout
+	r := newTestRerun(t, config)
+	runRerun(t, r)
+	waitEvent(t, r, CommandStarted)
+
+	writeFile(t, dir, "src/a.txt")
+	deadline := time.Now().Add(eventTimeout)
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "stopping")); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Command wasn't stopped for the change")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	writeFile(t, dir, "src/b.txt")
+	writeFile(t, dir, "src/c.txt")
+
+	// One run for the first change and one for those made while restarting
+	waitEvent(t, r, CommandStarted)
+	waitEvent(t, r, CommandStarted)
+	noEvent(t, r, CommandStarted)
+}
+
+func TestDebouncePerDir(t *testing.T) {
+	dir := t.TempDir()
+	mkdirs(t, dir, "a", "b")
+	config := testConfig(t, dir, "echo $RERUN_CHANGED_FILES")
+	config.Dirs = []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+	config.Debounce = 200 * time.Millisecond
+	config.DebouncePerDir = true
+	out := &syncBuffer{}
+	config.Stdout = out
+	r := newTestRerun(t, config)
+	runRerun(t, r)
+	waitRun(t, r)
+
+	// A change in each directory reruns the command for it
+	writeFile(t, dir, "a/file.txt")
+	waitRun(t, r)
+	writeFile(t, dir, "b/file.txt")
+	waitRun(t, r)
+	noEvent(t, r, CommandStarted)
+	want := filepath.FromSlash("\na/file.txt\nb/file.txt\n")
+	if got := strings.Replace(out.String(), dir+string(filepath.Separator), "", -1); got != want {
+		t.Errorf("Runs changed %q, want %q", got, want)
+	}
+}
+
+func TestDebouncePerDirDoesntHoldBack(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		perDir bool
+	}{
+		{"per dir", true},
+		{"shared", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			mkdirs(t, dir, "a", "b")
+			config := testConfig(t, dir, "true")
+			config.Dirs = []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+			config.Debounce = 400 * time.Millisecond
+			config.DebouncePerDir = test.perDir
+			r := newTestRerun(t, config)
+			runRerun(t, r)
+			waitRun(t, r)
+
+			// The change in b only holds back the rerun for the change in a
+			// when they share a debounce period
+			start := time.Now()
+			writeFile(t, dir, "a/file.txt")
+			time.Sleep(200 * time.Millisecond)
+			writeFile(t, dir, "b/file.txt")
+			waitEvent(t, r, CommandStarted)
+			if held := time.Since(start) > 500*time.Millisecond; held != !test.perDir {
+				t.Errorf("Rerun after %s, held back by the change in b = %v, want %v", time.Since(start), held, !test.perDir)
+			}
+		})
+	}
+}
+
+func TestRestartKeepsEachRunsOutput(t *testing.T) {
+	dir := t.TempDir()
+	// Each line is tagged with the run's shell and numbered, and the run ends
+	// by saying how many lines it wrote, even when it's stopped part way
+	padding := strings.Repeat("x", 100)
+	config := testConfig(t, dir, `trap 'echo "$$ end $i"; exit' TERM; i=0
+while [ $i -lt 20000 ]; do
+	echo "$$ $i `+padding+`"; i=$((i+1))
+	if [ $((i % 200)) = 0 ]; then sleep 0.01; fi
+done
+trap - TERM; echo "$$ end $i"; sleep 100`)
+	config.Grace = eventTimeout
+	out := &syncBuffer{}
+	config.Stdout = out
+	r := newTestRerun(t, config)
+
+	r.Start()
+	deadline := time.Now().Add(eventTimeout)
+	for strings.Count(out.String(), "\n") < 1000 {
+		if time.Now().After(deadline) {
+			t.Fatal("Command didn't write its output")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	r.Restart()
+	for strings.Count(out.String(), " end ") < 2 {
+		if time.Now().After(deadline.Add(eventTimeout)) {
+			t.Fatal("Restarted command didn't finish writing its output")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	r.Stop()
+
+	// Each run's lines are all there, in order, before the next run's
+	var runs []string
+	next := 0
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			t.Fatalf("Garbled line %q", line)
+		}
+		if len(runs) == 0 || fields[0] != runs[len(runs)-1] {
+			if len(runs) > 0 && next >= 0 {
+				t.Fatalf("Run %s's output was interleaved with or cut off by the next run's", runs[len(runs)-1])
+			}
+			runs = append(runs, fields[0])
+			next = 0
+		}
+		if next < 0 {
+			t.Fatalf("Run %s wrote %q after it ended", fields[0], line)
+		}
+		if fields[1] == "end" {
+			if fields[2] != strconv.Itoa(next) {
+				t.Errorf("Run %s wrote %s lines but %d were copied", fields[0], fields[2], next)
+			}
+			if len(runs) == 1 && next == 20000 {
+				t.Error("The first run finished before it was restarted")
+			}
+			next = -1
+			continue
+		}
+		if fields[1] != strconv.Itoa(next) || fields[2] != padding {
+			t.Fatalf("Run %s wrote %q, want line %d", fields[0], line, next)
+		}
+		next++
+	}
+	if len(runs) != 2 {
+		t.Errorf("Found the output of %d runs, want 2", len(runs))
+	}
+}
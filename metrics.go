@@ -0,0 +1,79 @@
+package rerun
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// durationBuckets are the upper bounds in seconds of the run duration
+// histogram's buckets
+var durationBuckets = [...]float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// serveMetrics starts serving /metrics in the Prometheus text format on
+// MetricsAddr
+func (r *Rerun) serveMetrics() error {
+	listener, err := net.Listen("tcp", r.MetricsAddr)
+	if err != nil {
+		return fmt.Errorf("Unable to listen on %s: %q", r.MetricsAddr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", r.handleMetrics)
+	r.metrics = &http.Server{Handler: mux}
+
+	log.Debugf("Serving metrics on %s", listener.Addr())
+	go func() {
+		err := r.metrics.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("Metrics server error: %q", err)
+		}
+	}()
+	return nil
+}
+
+// closeMetrics shuts down the metrics server if it's running
+func (r *Rerun) closeMetrics() {
+	if r.metrics == nil {
+		return
+	}
+	log.Debug("Stopping the metrics server")
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	r.metrics.Shutdown(ctx)
+}
+
+// handleMetrics writes the stats for GET /metrics
+func (r *Rerun) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	stats := r.Stats()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeCounter(w, "rerun_runs_total", "Runs of the command which exited on their own", stats.Runs)
+	writeCounter(w, "rerun_failures_total", "Runs of the command which exited with a non-zero status", stats.Failures)
+	writeCounter(w, "rerun_events_total", "Filesystem events received", stats.Events)
+	writeCounter(w, "rerun_events_ignored_total", "Filesystem events which didn't rerun the command", stats.Ignored)
+
+	fmt.Fprintln(w, "# HELP rerun_run_duration_seconds How long runs of the command took")
+	fmt.Fprintln(w, "# TYPE rerun_run_duration_seconds histogram")
+	count := 0
+	for i, bound := range durationBuckets {
+		count += stats.durations[i]
+		fmt.Fprintf(w, "rerun_run_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), count)
+	}
+	fmt.Fprintf(w, "rerun_run_duration_seconds_bucket{le=\"+Inf\"} %d\n", stats.Runs)
+	fmt.Fprintf(w, "rerun_run_duration_seconds_sum %g\n", stats.Total.Seconds())
+	fmt.Fprintf(w, "rerun_run_duration_seconds_count %d\n", stats.Runs)
+}
+
+// writeCounter writes the counter with its help text
+func writeCounter(w io.Writer, name, help string, value int) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+}
@@ -0,0 +1,98 @@
+package rerun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// notifyTailLines is how many lines of stderr are included in the
+	// notification for a failed run
+	notifyTailLines = 5
+	// notifyTimeout is how long to wait for a desktop notification to be sent
+	notifyTimeout = 5 * time.Second
+)
+
+// Notifier sends a notification when a run finishes
+type Notifier interface {
+	Notify(title, message string) error
+}
+
+// DesktopNotifier sends desktop notifications using notify-send on Linux,
+// osascript on macOS and toast on Windows
+type DesktopNotifier struct{}
+
+// Notify implements Notifier
+func (DesktopNotifier) Notify(title, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.CommandContext(ctx, "notify-send", title, message)
+	case "darwin":
+		// Pass the text as arguments so it doesn't need escaping
+		cmd = exec.CommandContext(ctx, "osascript",
+			"-e", "on run argv",
+			"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+			"-e", "end run",
+			title, message)
+	case "windows":
+		cmd = exec.CommandContext(ctx, "toast", "--app-id", "rerun", "--title", title, "--message", message)
+	default:
+		return errors.New("desktop notifications aren't supported on " + runtime.GOOS)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// notify sends a notification for a run which exited on its own
+func (r *Rerun) notify(code int, stderr string) {
+	if r.Notifier == nil {
+		return
+	}
+	title := "rerun: " + r.currentCommand()
+	message := "Succeeded"
+	if code != 0 {
+		message = fmt.Sprintf("Failed with exit status %d", code)
+		if tail := tailLines(stderr, notifyTailLines); tail != "" {
+			message += "\n" + tail
+		}
+	}
+	err := r.Notifier.Notify(title, message)
+	if err != nil {
+		log.Debugf("Unable to send notification: %q", err)
+	}
+}
+
+// notifyReady sends a notification once the command is accepting
+// connections on WaitFor
+func (r *Rerun) notifyReady() {
+	if r.Notifier == nil {
+		return
+	}
+	err := r.Notifier.Notify("rerun: "+r.currentCommand(), "Ready on "+r.WaitFor)
+	if err != nil {
+		log.Debugf("Unable to send notification: %q", err)
+	}
+}
+
+// tailLines returns the last n lines of s
+func tailLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
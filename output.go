@@ -0,0 +1,115 @@
+package rerun
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxOutput is how many bytes of each output stream are kept for a run, older
+// output is discarded
+const maxOutput = 1 << 20
+
+// outputBuffer captures the tail of a run's output and is guarded by the
+// rerun's mutex
+type outputBuffer struct {
+	mu  *sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write implements io.Writer
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Write(p)
+	if extra := b.buf.Len() - maxOutput; extra > 0 {
+		b.buf.Next(extra)
+	}
+	return len(p), nil
+}
+
+// LastOutput returns the stdout and stderr of the current or most recent run
+func (r *Rerun) LastOutput() (stdout, stderr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stdout == nil {
+		return "", ""
+	}
+	return r.stdout.buf.String(), r.stderr.buf.String()
+}
+
+// prefixWriter writes the prefix at the start of each line. Output is written
+// through as soon as it arrives rather than waiting for the end of the line,
+// so partial lines are never held back
+type prefixWriter struct {
+	w      io.Writer
+	prefix func() string
+	// midLine is set until the current line ends
+	midLine bool
+}
+
+// Write implements io.Writer
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	var out bytes.Buffer
+	for len(b) > 0 {
+		if !p.midLine {
+			out.WriteString(p.prefix())
+			p.midLine = true
+		}
+		line := b
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			line = b[:i+1]
+			p.midLine = false
+		}
+		out.Write(line)
+		b = b[len(line):]
+	}
+	_, err := p.w.Write(out.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// prefixed returns the writer with the Prefix written at the start of each
+// line, replacing {time} with the current time and {event} with RERUN_EVENT
+func (r *Rerun) prefixed(w io.Writer, reason reason) io.Writer {
+	if r.Prefix == "" {
+		return w
+	}
+	prefix := strings.Replace(r.Prefix, "{event}", reason.event, -1)
+	return &prefixWriter{w: w, prefix: func() string {
+		return strings.Replace(prefix, "{time}", time.Now().Format("15:04:05"), -1)
+	}}
+}
+
+// lineWriter remembers whether the output written through it stopped part way
+// through a line, such as when a command is killed while printing
+type lineWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	midLine bool
+}
+
+// Write implements io.Writer
+func (l *lineWriter) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(b) > 0 {
+		l.midLine = b[len(b)-1] != '\n'
+	}
+	return l.w.Write(b)
+}
+
+// endLine finishes a partial line so the next run's output starts on its own
+func (l *lineWriter) endLine() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.midLine {
+		l.w.Write([]byte("\n"))
+		l.midLine = false
+	}
+}
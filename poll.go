@@ -0,0 +1,205 @@
+package rerun
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultPollInterval is how often polled directories are checked for changes
+const DefaultPollInterval = time.Second
+
+// pollInterval returns how often to poll the watched tree, or zero when using
+// filesystem events
+func (r *Rerun) pollInterval() time.Duration {
+	if r.PollInterval > 0 {
+		return r.PollInterval
+	}
+	if r.Polling {
+		return DefaultPollInterval
+	}
+	return 0
+}
+
+// snapshot walks the watched tree and returns the state of every path in it
+func (r *Rerun) snapshot() map[string]fileState {
+	files := make(map[string]fileState)
+	for _, root := range r.roots {
+		filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+			if f == nil {
+				return nil
+			}
+			if f.IsDir() && r.skipDir(path, f) {
+				return filepath.SkipDir
+			}
+			files[path] = stateOf(f)
+			return nil
+		})
+	}
+	for path := range r.watchFiles {
+		if info, err := os.Stat(path); err == nil {
+			files[path] = stateOf(info)
+		}
+	}
+	return files
+}
+
+// pollTree walks the watched tree and returns events for any changes since the
+// previous poll
+func (r *Rerun) pollTree() []fsnotify.Event {
+	current := r.snapshot()
+	events := diffStates(r.files, current)
+	r.files = current
+	return events
+}
+
+// diffStates returns events for the differences between two sets of paths
+func diffStates(previous, current map[string]fileState) []fsnotify.Event {
+	var events []fsnotify.Event
+	for path, state := range current {
+		old, ok := previous[path]
+		if !ok {
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Create})
+		} else if !state.isDir && (!state.modTime.Equal(old.modTime) || state.size != old.size) {
+			// Directories change whenever their contents do, which is
+			// reported for the contents themselves
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Write})
+		}
+	}
+	for path := range previous {
+		if _, ok := current[path]; !ok {
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Remove})
+		}
+	}
+	return events
+}
+
+// fileState is what's compared between polls to detect a change
+type fileState struct {
+	modTime time.Time
+	size    int64
+	isDir   bool
+}
+
+// poller detects changes in directories by periodically listing them, for
+// directories which can't be watched with fsnotify
+type poller struct {
+	interval time.Duration
+	events   chan fsnotify.Event
+	done     chan struct{}
+
+	mu   sync.Mutex
+	dirs map[string]map[string]fileState
+}
+
+// newPoller returns a poller which checks for changes every interval
+func newPoller(interval time.Duration) *poller {
+	p := &poller{
+		interval: interval,
+		events:   make(chan fsnotify.Event, 64),
+		done:     make(chan struct{}),
+		dirs:     make(map[string]map[string]fileState),
+	}
+	go p.loop()
+	return p
+}
+
+// add starts polling the directory for changes
+func (p *poller) add(dir string) error {
+	entries, err := readDir(dir)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.dirs[dir] = entries
+	p.mu.Unlock()
+	return nil
+}
+
+// remove stops polling the directory, reporting whether it was being polled
+func (p *poller) remove(dir string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.dirs[dir]
+	delete(p.dirs, dir)
+	return ok
+}
+
+// close stops polling
+func (p *poller) close() {
+	close(p.done)
+}
+
+// loop polls the directories every interval until the poller is closed
+func (p *poller) loop() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			// Send events without holding the lock so the main loop can add
+			// directories while we're blocked
+			for _, event := range p.poll() {
+				select {
+				case p.events <- event:
+				case <-p.done:
+					return
+				}
+			}
+		}
+	}
+}
+
+// poll lists each directory and returns events for any changes since the
+// previous poll
+func (p *poller) poll() []fsnotify.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var events []fsnotify.Event
+	for dir, previous := range p.dirs {
+		current, err := readDir(dir)
+		if err != nil {
+			log.Debugf("Unable to poll %q: %q", dir, err)
+			delete(p.dirs, dir)
+			events = append(events, fsnotify.Event{Name: dir, Op: fsnotify.Remove})
+			continue
+		}
+		events = append(events, diffStates(paths(dir, previous), paths(dir, current))...)
+		p.dirs[dir] = current
+	}
+	return events
+}
+
+// paths returns the states of a directory's entries keyed by their full path
+func paths(dir string, entries map[string]fileState) map[string]fileState {
+	states := make(map[string]fileState, len(entries))
+	for name, state := range entries {
+		states[filepath.Join(dir, name)] = state
+	}
+	return states
+}
+
+// readDir returns the state of each entry in the directory
+func readDir(dir string) (map[string]fileState, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]fileState, len(infos))
+	for _, info := range infos {
+		entries[info.Name()] = stateOf(info)
+	}
+	return entries, nil
+}
+
+// stateOf returns the state of a file to compare between polls
+func stateOf(info os.FileInfo) fileState {
+	return fileState{modTime: info.ModTime(), size: info.Size(), isDir: info.IsDir()}
+}
@@ -0,0 +1,138 @@
+//go:build !windows
+// +build !windows
+
+package rerun
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// DefaultShell is the shell used to run commands when none is configured
+const DefaultShell = "sh"
+
+// signals are the signals ParseSignal accepts by name
+var signals = map[string]os.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"TERM":  syscall.SIGTERM,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// setProcessGroup runs the command in its own process group so signals reach
+// any children spawned by the shell
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// ownGroup reports whether the command is in its own process group, either
+// made for it or as part of a new session
+func ownGroup(cmd *exec.Cmd) bool {
+	return cmd.SysProcAttr != nil && (cmd.SysProcAttr.Setpgid || cmd.SysProcAttr.Setsid)
+}
+
+// credential is the user and group IDs to run the command as
+type credential struct {
+	uid uint32
+	gid uint32
+}
+
+// resolveCredential looks up the user and group, by name or ID, to run the
+// command as. The group defaults to the user's primary group and the user to
+// rerun's own
+func resolveCredential(username, group string) (*credential, error) {
+	c := &credential{uid: uint32(os.Getuid()), gid: uint32(os.Getgid())}
+	if username != "" {
+		u, err := user.Lookup(username)
+		if _, numeric := strconv.Atoi(username); err != nil && numeric == nil {
+			u, err = user.LookupId(username)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to find user %q: %q", username, err)
+		}
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+		c.uid, c.gid = uint32(uid), uint32(gid)
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if _, numeric := strconv.Atoi(group); err != nil && numeric == nil {
+			g, err = user.LookupGroupId(group)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to find group %q: %q", group, err)
+		}
+		gid, _ := strconv.ParseUint(g.Gid, 10, 32)
+		c.gid = uint32(gid)
+	}
+	// Only root can run processes as someone else
+	if os.Geteuid() != 0 && (c.uid != uint32(os.Geteuid()) || c.gid != uint32(os.Getegid())) {
+		return nil, fmt.Errorf("Unable to run the command as user %d and group %d: rerun must run as root", c.uid, c.gid)
+	}
+	return c, nil
+}
+
+// set makes the command run as the credential's user and group, if there is
+// one
+func (c *credential) set(cmd *exec.Cmd) {
+	if c == nil {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: c.uid, Gid: c.gid}
+}
+
+// checkNice makes sure the command can be run with the nice value
+func checkNice(nice int) error {
+	if nice < -20 || nice > 19 {
+		return fmt.Errorf("Invalid nice value %d: must be from -20 to 19", nice)
+	}
+	if nice < 0 && os.Geteuid() != 0 {
+		return fmt.Errorf("Unable to run the command with nice value %d: rerun must run as root to raise its priority", nice)
+	}
+	return nil
+}
+
+// setNice sets the nice value of the command's process group, which covers
+// any children the shell has already started, or just the command when it
+// shares rerun's process group
+func setNice(cmd *exec.Cmd, nice int) error {
+	if ownGroup(cmd) {
+		return syscall.Setpriority(syscall.PRIO_PGRP, cmd.Process.Pid, nice)
+	}
+	return syscall.Setpriority(syscall.PRIO_PROCESS, cmd.Process.Pid, nice)
+}
+
+// terminate asks the command's process group to exit with the signal, or
+// SIGTERM when it's nil
+func terminate(cmd *exec.Cmd, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		s = syscall.SIGTERM
+	}
+	return signalGroup(cmd, s)
+}
+
+// kill forcibly kills the command's process group with SIGKILL
+func kill(cmd *exec.Cmd) error {
+	return signalGroup(cmd, syscall.SIGKILL)
+}
+
+// signalGroup sends the signal to the command's process group, or just the
+// command when it shares rerun's process group
+func signalGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	pid := cmd.Process.Pid
+	if ownGroup(cmd) {
+		pid = -pid
+	}
+	return syscall.Kill(pid, sig)
+}
@@ -0,0 +1,112 @@
+//go:build !windows
+// +build !windows
+
+package rerun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// alive reports whether the process is running, counting zombies left for an
+// init which doesn't reap them as gone
+func alive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+// waitGone waits for the process to exit, reporting whether it did
+func waitGone(pid int) bool {
+	deadline := time.Now().Add(eventTimeout)
+	for time.Now().Before(deadline) {
+		if !alive(pid) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestStopKillsProcessGroup(t *testing.T) {
+	dir := t.TempDir()
+	r := newTestRerun(t, testConfig(t, dir, "sleep 100 & echo $! > pid; wait"))
+
+	r.Start()
+	waitEvent(t, r, CommandStarted)
+	var child int
+	deadline := time.Now().Add(eventTimeout)
+	for child == 0 && time.Now().Before(deadline) {
+		out, _ := ioutil.ReadFile(filepath.Join(dir, "pid"))
+		if strings.HasSuffix(string(out), "\n") {
+			child, _ = strconv.Atoi(strings.TrimSpace(string(out)))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if child == 0 {
+		t.Fatal("Command didn't start sleep")
+	}
+	r.mu.Lock()
+	shell := r.cmd.Process.Pid
+	r.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		r.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(eventTimeout):
+		syscall.Kill(child, syscall.SIGKILL)
+		t.Fatal("Stop() didn't return, waiting for sleep started by the shell")
+	}
+	if !waitGone(shell) {
+		t.Errorf("Shell %d is still running after Stop()", shell)
+	}
+	if !waitGone(child) {
+		syscall.Kill(child, syscall.SIGKILL)
+		t.Errorf("sleep %d started by the shell is still running after Stop()", child)
+	}
+}
+
+func TestSignalCause(t *testing.T) {
+	dir := t.TempDir()
+	r := newTestRerun(t, testConfig(t, dir, "sleep 100"))
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Run(context.Background())
+	}()
+	waitEvent(t, r, CommandStarted)
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	if stopped := waitEvent(t, r, CommandStopped); stopped.Reason != ErrShutdown.Error() {
+		t.Errorf("Stopped since %q, want %q", stopped.Reason, ErrShutdown)
+	}
+	select {
+	case err := <-done:
+		var signalErr *SignalError
+		if !errors.As(err, &signalErr) || signalErr.Signal != syscall.SIGTERM {
+			t.Errorf("Run() = %v, want a SignalError for SIGTERM", err)
+		}
+	case <-time.After(eventTimeout):
+		t.Fatal("Run() didn't return after SIGTERM")
+	}
+}
@@ -0,0 +1,63 @@
+package rerun
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// DefaultShell is the shell used to run commands when none is configured
+const DefaultShell = "cmd"
+
+// signals are the signals ParseSignal accepts by name, which are none since
+// Windows can't send signals to other processes
+var signals = map[string]os.Signal{}
+
+// setProcessGroup runs the command in a new process group so console control
+// events for rerun aren't also sent to the command
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// credential is who the command runs as, which can't be changed on Windows
+type credential struct{}
+
+// resolveCredential fails since Windows can't run the command as another user
+func resolveCredential(username, group string) (*credential, error) {
+	return nil, errors.New("Running the command as another user or group isn't supported on Windows")
+}
+
+// set does nothing since there's never a credential on Windows
+func (c *credential) set(cmd *exec.Cmd) {}
+
+// checkNice fails since Windows doesn't have nice values
+func checkNice(nice int) error {
+	return errors.New("Setting the command's nice value isn't supported on Windows")
+}
+
+// setNice does nothing since checkNice never allows a nice value on Windows
+func setNice(cmd *exec.Cmd, nice int) error {
+	return nil
+}
+
+// terminate asks the command and its children to close since Windows can't
+// deliver SIGTERM or the signal
+func terminate(cmd *exec.Cmd, sig os.Signal) error {
+	return taskkill("/T", "/PID", strconv.Itoa(cmd.Process.Pid))
+}
+
+// kill forcibly kills the command and its children
+func kill(cmd *exec.Cmd) error {
+	err := taskkill("/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid))
+	if err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
+
+// taskkill runs taskkill.exe to signal the command's process tree
+func taskkill(args ...string) error {
+	return exec.Command("taskkill", args...).Run()
+}
@@ -0,0 +1,147 @@
+package rerun
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ptySupported reports whether commands can be run in a pseudo-terminal
+const ptySupported = true
+
+// ptyDrainTimeout is how long to keep copying output after the command exits,
+// in case something it started still has the terminal open
+const ptyDrainTimeout = 100 * time.Millisecond
+
+// pty is a pseudo-terminal the command runs in
+type pty struct {
+	master *os.File
+	slave  *os.File
+	copied chan struct{}
+	winch  chan os.Signal
+	// copying is set once the command started and its output is copied
+	copying bool
+}
+
+// openPTY allocates a pseudo-terminal and makes it the command's stdin,
+// stdout, stderr and controlling terminal, in a new session
+func openPTY(cmd *exec.Cmd) (*pty, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open a pseudo-terminal: %q", err)
+	}
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("Unable to unlock the pseudo-terminal: %q", err)
+	}
+	var n uint32
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("Unable to find the pseudo-terminal: %q", err)
+	}
+	slave, err := os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("Unable to open the pseudo-terminal: %q", err)
+	}
+	// Leave newlines alone so output matches what the command wrote
+	var termios syscall.Termios
+	if err := ioctl(slave, syscall.TCGETS, unsafe.Pointer(&termios)); err == nil {
+		termios.Oflag &^= syscall.ONLCR
+		ioctl(slave, syscall.TCSETS, unsafe.Pointer(&termios))
+	}
+
+	p := &pty{master: master, slave: slave, copied: make(chan struct{}), winch: make(chan os.Signal, 1)}
+	p.resize()
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	// The new session is also a new process group, and a session leader
+	// can't change its process group
+	cmd.SysProcAttr.Setpgid = false
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+	return p, nil
+}
+
+// started copies the command's output to out and passes on changes to
+// rerun's terminal size once the command is running
+func (p *pty) started(out io.Writer) {
+	// Only the command should have the terminal open, so reads end once
+	// it exits
+	p.slave.Close()
+	p.copying = true
+	go func() {
+		io.Copy(out, p.master)
+		close(p.copied)
+	}()
+	signal.Notify(p.winch, syscall.SIGWINCH)
+	go func() {
+		for range p.winch {
+			p.resize()
+		}
+	}()
+}
+
+// close waits for the rest of the command's output then frees the terminal.
+// There's nothing to wait for if the command couldn't be started
+func (p *pty) close() {
+	signal.Stop(p.winch)
+	close(p.winch)
+	p.slave.Close()
+	if !p.copying {
+		p.master.Close()
+		return
+	}
+	select {
+	case <-p.copied:
+	case <-time.After(ptyDrainTimeout):
+		log.Debug("Command's pseudo-terminal is still open, so the rest of its output is dropped")
+	}
+	p.master.Close()
+}
+
+// resize sets the pseudo-terminal's size to that of rerun's terminal, if it
+// has one
+func (p *pty) resize() {
+	var size struct{ rows, cols, x, y uint16 }
+	for _, f := range []*os.File{os.Stdout, os.Stderr, os.Stdin} {
+		if ioctl(f, syscall.TIOCGWINSZ, unsafe.Pointer(&size)) == nil {
+			ioctl(p.master, syscall.TIOCSWINSZ, unsafe.Pointer(&size))
+			return
+		}
+	}
+}
+
+// ioctl runs the terminal ioctl request on the file, without taking it out
+// of non-blocking mode as Fd would
+func ioctl(f *os.File, request uintptr, arg unsafe.Pointer) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
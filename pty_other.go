@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package rerun
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+)
+
+// ptySupported reports whether commands can be run in a pseudo-terminal
+const ptySupported = false
+
+// pty is a pseudo-terminal the command runs in, which is only supported on
+// Linux
+type pty struct{}
+
+func openPTY(cmd *exec.Cmd) (*pty, error) {
+	return nil, errors.New("Pseudo-terminals are only supported on Linux")
+}
+
+func (p *pty) started(out io.Writer) {}
+
+func (p *pty) close() {}
@@ -0,0 +1,54 @@
+package rerun
+
+import (
+	"context"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultWaitForTimeout is how long to wait for WaitFor to be reachable
+	DefaultWaitForTimeout = 30 * time.Second
+	// waitForInterval is how often WaitFor is tried
+	waitForInterval = 100 * time.Millisecond
+)
+
+// waitReady tries to connect to WaitFor in a go routine until it's reachable,
+// the timeout passes or the returned function is called because the run has
+// exited
+func (r *Rerun) waitReady(ctx context.Context, reason reason) context.CancelFunc {
+	ctx, cancel := context.WithCancel(ctx)
+	timeout := r.WaitForTimeout
+	if timeout <= 0 {
+		timeout = DefaultWaitForTimeout
+	}
+	go func() {
+		fields := log.Fields{"command": r.currentCommand(), "addr": r.WaitFor}
+		deadline := time.After(timeout)
+		ticker := time.NewTicker(waitForInterval)
+		defer ticker.Stop()
+		for {
+			conn, err := net.DialTimeout("tcp", r.WaitFor, waitForInterval)
+			if err == nil {
+				conn.Close()
+				log.WithFields(fields).Infof("Command is ready since %s is accepting connections", r.WaitFor)
+				r.emit(LifecycleEvent{Type: CommandReady})
+				r.notifyReady()
+				r.runReadyHook(reason)
+				return
+			}
+			select {
+			case <-ctx.Done():
+				log.WithFields(fields).Debugf("Stopped waiting for %s since the command exited", r.WaitFor)
+				return
+			case <-deadline:
+				log.WithFields(fields).Warnf("Command still isn't accepting connections on %s after %s", r.WaitFor, timeout)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return cancel
+}
@@ -0,0 +1,123 @@
+package rerun
+
+import (
+	"reflect"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// reloaded is a config given to Reload() along with its parsed commands
+type reloaded struct {
+	config  Config
+	steps   []step
+	command string
+}
+
+// Reload applies the Ignore, IgnoreDirs, Include and Rules patterns, Delay,
+// Debounce, Shell and the command from the config while Run() keeps watching,
+// restarting the command if it changed. IgnoreDirs only applies to
+// directories created afterwards
+func (r *Rerun) Reload(config Config) error {
+	log.WithField("command", r.currentCommand()).Debug("Called Reload()")
+	steps, command, err := parseSteps(config)
+	if err != nil {
+		return err
+	}
+	// Only the latest config matters if the main loop hasn't applied an
+	// earlier one yet
+	select {
+	case <-r.reconfigure:
+	default:
+	}
+	r.reconfigure <- reloaded{config: config, steps: steps, command: command}
+	return nil
+}
+
+// reload applies a config given to Reload() from the main loop, logging what
+// changed
+func (r *Rerun) reload(next reloaded) {
+	var changed []string
+	update := func(name string, current, value interface{}) bool {
+		if reflect.DeepEqual(current, value) {
+			return false
+		}
+		changed = append(changed, name)
+		return true
+	}
+	if update("ignore", r.Ignore, next.config.Ignore) {
+		r.Ignore = next.config.Ignore
+	}
+	if update("ignore_dirs", r.IgnoreDirs, next.config.IgnoreDirs) {
+		r.IgnoreDirs = next.config.IgnoreDirs
+	}
+	if update("include", r.Include, next.config.Include) {
+		r.Include = next.config.Include
+	}
+	if update("rules", r.Rules, next.config.Rules) {
+		r.Rules = next.config.Rules
+	}
+	if update("debounce", r.Debounce, next.config.Debounce) {
+		r.Debounce = next.config.Debounce
+	}
+	if update("delay", r.Delay, next.config.Delay) {
+		r.Delay = next.config.Delay
+	}
+	commandChanged := update("command", r.steps, next.steps)
+	shellChanged := update("shell", r.Shell, next.config.Shell)
+	if !reflect.DeepEqual(r.Dirs, next.config.Dirs) {
+		log.Warn("Restart rerun to watch different directories, they aren't changed by reloading the config")
+	}
+	if len(changed) == 0 {
+		log.Info("Reloaded the config, nothing changed")
+		return
+	}
+	log.WithField("changed", strings.Join(changed, ",")).Infof("Reloaded the config, changing %s", strings.Join(changed, ", "))
+	if !commandChanged && !shellChanged {
+		return
+	}
+
+	// Swap the command while it's stopped so no run sees half of the change
+	r.control.Lock()
+	defer r.control.Unlock()
+	r.stop(ErrReloaded)
+	r.waitRestartDelay()
+	r.resetBackoff()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commandMu.Lock()
+	r.steps = next.steps
+	r.Command = next.command
+	r.Shell = next.config.Shell
+	r.commandMu.Unlock()
+	r.reason = reason{event: reasonManual}
+	r.start()
+}
+
+// currentCommand returns the command, which may be replaced by reloading the
+// config
+func (r *Rerun) currentCommand() string {
+	r.commandMu.RLock()
+	defer r.commandMu.RUnlock()
+	return r.Command
+}
+
+// currentShell returns the shell, which may be replaced by reloading the
+// config
+func (r *Rerun) currentShell() string {
+	r.commandMu.RLock()
+	defer r.commandMu.RUnlock()
+	return r.Shell
+}
+
+// reloadConfig reloads the config from ReloadConfig for SIGHUP
+func (r *Rerun) reloadConfig() {
+	log.Info("Reloading the config since SIGHUP was received")
+	config, err := r.ReloadConfig()
+	if err == nil {
+		err = r.Reload(config)
+	}
+	if err != nil {
+		log.WithField("error", err.Error()).Errorf("Unable to reload the config: %q", err)
+	}
+}
@@ -0,0 +1,1995 @@
+// Package rerun reruns a command whenever a file in a watched directory
+// changes
+package rerun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// clearScreen is the ANSI escape sequence to move the cursor to the top left
+// and clear the terminal
+const clearScreen = "\033[H\033[2J"
+
+// clearScrollback resets the terminal, then clears the screen and its
+// scrollback for terminals which don't clear it on reset
+const clearScrollback = "\033c" + clearScreen + "\033[3J"
+
+// Config defines the command to rerun and how to watch for changes
+type Config struct {
+	// Command is run with Shell
+	Command string
+	// Commands are run one after another with Shell on each change instead
+	// of Command
+	Commands []string
+	// FailFast stops running Commands at the first which fails
+	FailFast bool
+	// FailOnStderr fails a run which exits with status 0 when it wrote
+	// anything to stderr, which is lost in PTY's output
+	FailOnStderr bool
+	// Args is the command's literal arguments, which are executed directly
+	// instead of running Command with a shell
+	Args []string
+	// Shell is the shell used to run the command, defaults to sh or cmd on
+	// Windows
+	Shell string
+	// NoShell splits the command into arguments and executes it directly
+	// instead of with a shell
+	NoShell bool
+	// Dir is the working directory of the command, defaults to rerun's
+	// working directory
+	Dir string
+	// LogFile is a file the command's stdout and stderr are appended to
+	LogFile string
+	// LogFileMaxSize is how many bytes LogFile can grow to before it's moved
+	// to LogFile.1 and a new one is started, zero means it grows forever
+	LogFileMaxSize int64
+	// Env are extra KEY=VALUE environment variables for the command
+	Env []string
+	// Dirs are the root directories to watch, defaults to the current
+	// directory
+	Dirs []string
+	// Files are individual files to watch, which are watched by watching
+	// the directories containing them. Changes to files listed here are never
+	// ignored
+	Files []string
+	// AlsoWatch are extra files and directories to watch outside of Dirs,
+	// which still default to the current directory. Include doesn't apply to
+	// them
+	AlsoWatch []string
+	// Watch are glob patterns for paths to watch which may not exist yet,
+	// where ** matches any number of directories. The nearest existing
+	// directory to each is watched for paths matching it
+	Watch []string
+	// WatchFrom is a file listing more paths and Watch patterns, one per
+	// line, which is read again whenever it changes
+	WatchFrom string
+	// Stdout and Stderr are where the command's output and the banner are
+	// written instead of rerun's own stdout and stderr when set
+	Stdout io.Writer
+	Stderr io.Writer
+	// Prefix is written at the start of each line of the command's output,
+	// with {time} and {event} replaced by the time and RERUN_EVENT
+	Prefix string
+	// Clear the terminal before each run
+	Clear bool
+	// ClearScrollback also clears the terminal's scrollback before each run,
+	// when stdout is a terminal, so older output can't be scrolled back to
+	ClearScrollback bool
+	// Banner prints a line before each run with the command, what triggered
+	// it and the time
+	Banner bool
+	// NoColor prints the banner without color even when stdout is a terminal
+	NoColor bool
+	// OnlyOnResultChange leaves out the banner and notification for runs
+	// which pass or fail the same as the one before, printing a line after
+	// the run instead when it starts passing or failing
+	OnlyOnResultChange bool
+	// Stdin connects the command to rerun's stdin for interactive commands
+	Stdin bool
+	// PTY runs the command in a pseudo-terminal so it keeps its colors and
+	// progress output, with stderr merged into stdout. Only supported on
+	// Linux, elsewhere the command's output is piped as usual
+	PTY bool
+	// Nice runs the command at a lower scheduling priority, from 1 to 19, or
+	// a higher one from -1 to -20 as root. Not supported on Windows
+	Nice int
+	// User and Group are the user and group to run the command as, by name
+	// or ID, which rerun needs to be root to change. Not supported on Windows
+	User  string
+	Group string
+	// StdinFile is opened again for each run and read by the command as its
+	// stdin, so it sees the file's latest contents
+	StdinFile string
+	// Debounce waits until no events have arrived for the duration before
+	// rerunning the command
+	Debounce time.Duration
+	// DebouncePerDir times Delay and Debounce separately for the changes in
+	// each watched directory, so changes in one don't hold back a rerun for
+	// another
+	DebouncePerDir bool
+	// Delay waits for the duration after the first event before rerunning the
+	// command, absorbing any events which arrive in the meantime
+	Delay time.Duration
+	// Ignore is a list of glob patterns for paths to ignore
+	Ignore []string
+	// IgnoreDirs is a list of glob patterns for directories to skip, which
+	// aren't watched or walked and never match files
+	IgnoreDirs []string
+	// Include is a list of glob patterns, when set only matching paths cause
+	// the command to rerun
+	Include []string
+	// Hidden watches directories starting with a dot, other than .git
+	Hidden bool
+	// NoEditorIgnore reruns for the editor files in EditorIgnore
+	NoEditorIgnore bool
+	// NoGitignore watches and reruns for paths ignored by .gitignore files
+	NoGitignore bool
+	// Since only watches sub directories which were modified within the
+	// duration to start faster on huge trees, watching the rest once
+	// something in their parent directory changes
+	Since time.Duration
+	// WatchNewOnly only watches the roots to start with, watching each sub
+	// directory once something next to it changes rather than walking the
+	// whole tree up front
+	WatchNewOnly bool
+	// FollowSymlinks watches the directories symlinks in the tree point to
+	FollowSymlinks bool
+	// NoRecursive only watches the root directories and not their sub
+	// directories
+	NoRecursive bool
+	// RecursiveDepth only watches sub directories up to this many levels
+	// below the root directories, 0 watches them all
+	RecursiveDepth int
+	// Polling polls the watched tree for changes every DefaultPollInterval
+	// instead of using filesystem events, which can be unreliable on network
+	// filesystems
+	Polling bool
+	// PollInterval polls the watched tree for changes at the interval instead
+	// of using filesystem events
+	PollInterval time.Duration
+	// SkipInitialRun waits for the first change before running the command
+	SkipInitialRun bool
+	// ErrorSummary prints the exit code and the end of the stderr of a failed
+	// run before the next run
+	ErrorSummary bool
+	// Summary prints how many runs passed and failed and how long they took
+	// on average to Stderr when rerun exits, rather than only logging it
+	Summary bool
+	// NoKillOnChange lets the command finish when something changes while it's
+	// running, rerunning it once it has instead of restarting it
+	NoKillOnChange bool
+	// Throttle runs the command at most once per duration however often
+	// things change, rerunning once it has passed for any changes since
+	Throttle time.Duration
+	// Ops only reruns the command for events with one of the operations, or
+	// any operation when zero
+	Ops fsnotify.Op
+	// DefaultFile is what {file} and the other placeholders in the command
+	// expand to when no file has changed, such as for the initial run
+	DefaultFile string
+	// InitialDelay waits before the first run, such as for services started
+	// along with rerun. Changes during the delay don't cause another run
+	InitialDelay time.Duration
+	// Keepalive restarts the command with an exponential backoff when it exits
+	// with a non-zero exit code
+	Keepalive bool
+	// IgnoreSelfChanges ignores changes made while the command is running and
+	// within SelfChangeSettle after it exits, assuming the command made them
+	IgnoreSelfChanges bool
+	// SelfChangeSettle is how long after the command exits changes are still
+	// ignored with IgnoreSelfChanges, defaults to DefaultSelfChangeSettle
+	SelfChangeSettle time.Duration
+	// ReloadSignal is sent to the running command when files change instead of
+	// restarting it, which only happens if the command has already exited
+	ReloadSignal os.Signal
+	// Rules decide whether to restart, signal or ignore the command for
+	// changed paths by the first rule matching each, the command is restarted
+	// if any path needs it
+	Rules []Rule
+	// CommandMaps run a different command for changes to paths matching each
+	// glob, by the first matching the most recently changed path. Other
+	// changes run the usual command, or nothing if there isn't one
+	CommandMaps []CommandMap
+	// MaxRestarts pauses restarting the command after it has been restarted
+	// this many times within RestartInterval, until no changes have been
+	// made for RestartInterval. Zero disables the limit
+	MaxRestarts int
+	// RestartInterval is the window for MaxRestarts, defaults to
+	// DefaultRestartInterval
+	RestartInterval time.Duration
+	// RestartOnSIGHUP restarts the command when rerun receives SIGHUP instead
+	// of exiting
+	RestartOnSIGHUP bool
+	// ReloadConfig is called when rerun receives SIGHUP, unless
+	// RestartOnSIGHUP, for a config to apply with Reload()
+	ReloadConfig func() (Config, error)
+	// HTTPAddr is the address to serve the HTTP control endpoints on, which
+	// are off when empty
+	HTTPAddr string
+	// OnSuccess and OnFailure are shell commands run after each run which
+	// exits with status 0 or with any other status, with RERUN_EXIT_CODE set
+	OnSuccess string
+	OnFailure string
+	// HookTimeout is how long OnSuccess and OnFailure may run,
+	// DefaultHookTimeout when zero
+	HookTimeout time.Duration
+	// OnReady is a shell command run in the background once, the first time
+	// the command is accepting connections on WaitFor or otherwise the first
+	// time it exits with status 0
+	OnReady string
+	// MetricsAddr is the address to serve /metrics on in the Prometheus text
+	// format, which is off when empty
+	MetricsAddr string
+	// WaitFor is a host:port to wait for after each start, logging and
+	// notifying once the command is accepting connections on it
+	WaitFor string
+	// WaitForTimeout is how long to wait for WaitFor, DefaultWaitForTimeout
+	// when zero
+	WaitForTimeout time.Duration
+	// Serve is the URL of a dev server to proxy on ServeAddr, reloading
+	// pages in the browser after each successful run, which is off when empty
+	Serve string
+	// ServeAddr is the address for the Serve proxy, DefaultServeAddr when
+	// empty
+	ServeAddr string
+	// Socket is the path of a Unix socket to stream lifecycle events to
+	// each connection on as JSON lines, which is off when empty
+	Socket string
+	// Until stops rerunning once a run's output matches, with Run returning
+	// nil
+	Until *regexp.Regexp
+	// While stops rerunning once a run's output doesn't match, with Run
+	// returning nil
+	While *regexp.Regexp
+	// Notifier is sent a notification whenever a run exits on its own
+	Notifier Notifier
+	// Timeout kills each run which takes longer than the duration, zero means
+	// runs never time out
+	Timeout time.Duration
+	// Grace is how long to wait for the command to exit after SIGTERM before
+	// sending SIGKILL, zero sends SIGKILL immediately
+	Grace time.Duration
+	// RestartDelay waits for the duration after stopping the command before
+	// starting it again, for commands which need a moment to free a port or
+	// lock file. It adds to every restart
+	RestartDelay time.Duration
+	// KillSignal is sent to stop the command instead of SIGTERM, such as
+	// SIGINT for commands which shut down gracefully on ctrl+c. SIGKILL kills
+	// the command straight away
+	KillSignal os.Signal
+}
+
+// DefaultGrace is the grace period used by the CLI
+const DefaultGrace = 5 * time.Second
+
+const (
+	// keepaliveMinBackoff is how long to wait before the first keepalive
+	// restart, doubling for each restart after it
+	keepaliveMinBackoff = time.Second
+	// keepaliveMaxBackoff is the longest wait between keepalive restarts
+	keepaliveMaxBackoff = 30 * time.Second
+	// duplicateWindow is how soon after an event for a path another event for
+	// the same path is treated as part of the same change
+	duplicateWindow = 50 * time.Millisecond
+	// renameWindow is how long a rename waits for the create of an atomic
+	// save, which renames the new file over the old one, before rerunning
+	renameWindow = 100 * time.Millisecond
+	// DefaultSelfChangeSettle is how long after the command exits changes are
+	// still ignored with IgnoreSelfChanges
+	DefaultSelfChangeSettle = 250 * time.Millisecond
+)
+
+// Rerun defines a command to rerun
+type Rerun struct {
+	sync.WaitGroup
+	Config
+	steps []step
+	// commandMu guards steps, Command and Shell, which reloading the config
+	// replaces while runs, lifecycle events and the status endpoint read them
+	commandMu sync.RWMutex
+	// mapSteps are the step for each of CommandMaps
+	mapSteps   []step
+	roots      []string
+	gitignores map[string][]gitignorePattern
+	watcher    *fsnotify.Watcher
+	poller     *poller
+	files      map[string]fileState
+	// watchFiles are the absolute paths of Files
+	watchFiles map[string]bool
+	// patterns are the absolute Watch patterns, with slashes as separators
+	patterns []string
+	// patternRoots are the roots which are only watched for the patterns
+	patternRoots map[string]bool
+	// watchFrom is the absolute path of WatchFrom and watchPatterns are the
+	// patterns from Watch, without those listed in it
+	watchFrom     string
+	watchPatterns []string
+	// extraRoots are the roots from AlsoWatch, which Include doesn't apply to
+	extraRoots map[string]bool
+	// stale are the sub directories of each directory which haven't been
+	// watched yet for Since
+	stale map[string][]string
+	// lazy is set while walking directories for WatchNewOnly
+	lazy bool
+	// created are files found inside new directories, which were created
+	// before the directories could be watched
+	created []string
+	// missingRoots are roots which were removed while watching them
+	missingRoots map[string]bool
+	// listing records the directories which would be watched in listed
+	// instead of watching them
+	listing bool
+	listed  []ListedDir
+	logFile *logFile
+	// dirs maps each watched directory's key from dirKey to the path it's
+	// watched at, when following symlinks
+	dirs map[interface{}]string
+	// watched are the directories in the filesystem watcher, for
+	// WatchedDirs()
+	watchedMu sync.Mutex
+	watched   map[string]bool
+	// watchLimitOnce warns about reaching the inotify watch limit once
+	watchLimitOnce sync.Once
+	// cleanupOnce makes cleanup() safe to call more than once
+	cleanupOnce sync.Once
+	lifecycle   chan LifecycleEvent
+	// subscribers also receive each lifecycle event
+	subscribers   []chan LifecycleEvent
+	subscribersMu sync.Mutex
+	// matched is closed when Until or While stop rerunning
+	matched     chan struct{}
+	matchedOnce sync.Once
+	// interrupted is closed once SIGINT or SIGTERM is received, which is
+	// then caught
+	interrupted chan struct{}
+	caught      os.Signal
+	// signalCh receives the signals handled by handleSignals until cleanup
+	signalCh        chan os.Signal
+	stopSignalsOnce sync.Once
+	// reconfigure receives configs given to Reload() for the main loop
+	reconfigure chan reloaded
+	// trigger receives restarts requested over HTTP or with SIGHUP
+	trigger chan struct{}
+	server  *http.Server
+	socket  *eventSocket
+	proxy   *http.Server
+	metrics *http.Server
+	// readyFired is set once OnReady has been run
+	readyFired bool
+	// credential is who the command runs as for User and Group
+	credential *credential
+	// stdoutLine and stderrLine are rerun's stdout and stderr for the command
+	stdoutLine *lineWriter
+	stderrLine *lineWriter
+
+	// control serializes Start(), Stop() and Restart() so a restart can't be
+	// interleaved with starting or stopping the command elsewhere
+	control sync.Mutex
+	// mu guards the run state below, which is shared between the main loop,
+	// command go routines and the signal handler
+	mu           sync.Mutex
+	exiting      bool
+	cmd          *exec.Cmd
+	finished     time.Time
+	lastExitCode int
+	stats        Stats
+	// stepExitCodes are the exit codes of each step of the last run
+	stepExitCodes []int
+	backoff       time.Duration
+	restarts      int
+	cancel        context.CancelCauseFunc
+	stdout        *outputBuffer
+	stderr        *outputBuffer
+	started       bool
+	reason        reason
+	// busy is set while a run's steps are executing
+	busy bool
+	// failed is the last run if it failed, for ErrorSummary
+	failed *failure
+	// queued is why to rerun the command once the current run finishes with
+	// NoKillOnChange
+	queued *reason
+}
+
+// Start runs the command in a go routine
+func (r *Rerun) Start() {
+	log.WithField("command", r.currentCommand()).Debug("Called Start()")
+	r.control.Lock()
+	defer r.control.Unlock()
+
+	// Hold the lock until the command's go routine has been added to the
+	// waitgroup so cleanup() either waits for it or it's never started
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reason = reason{event: reasonManual}
+	if !r.started {
+		r.reason.event = reasonInitial
+	}
+	r.start()
+}
+
+// start runs the command in a go routine, r.mu must be held
+func (r *Rerun) start() {
+	// Make sure we're not exiting
+	if r.exiting {
+		log.WithField("command", r.currentCommand()).Debug("Not starting the command since rerun is exiting")
+		return
+	}
+	if steps, _ := r.stepsFor(r.reason); len(steps) == 0 {
+		log.Debug("Not running anything since no command is mapped to the change and there's no usual command")
+		return
+	}
+
+	// Create context with a cancel function, releasing the previous one
+	if r.cancel != nil {
+		r.cancel(stopCause(r.reason))
+	}
+	var ctx context.Context
+	ctx, r.cancel = context.WithCancelCause(context.Background())
+
+	// The previous run has exited and its output has been copied by now, but
+	// it may have been killed part way through a line
+	r.stdoutLine.endLine()
+	r.stderrLine.endLine()
+
+	// Clear the terminal before any output from the new command
+	if r.ClearScrollback && r.Stdout == nil && isTerminal(os.Stdout) {
+		fmt.Fprint(r.stdoutLine, clearScrollback)
+	} else if r.Clear {
+		fmt.Fprint(r.stdoutLine, clearScreen)
+	}
+	// Remind about the last run's failure in case it has scrolled away
+	if r.ErrorSummary && r.failed != nil {
+		fmt.Fprintln(r.stderrLine, r.errorSummary(r.failed))
+		r.failed = nil
+	}
+	if r.Banner && !r.OnlyOnResultChange {
+		fmt.Fprintln(r.stdoutLine, r.banner(r.reason))
+	}
+
+	// Start execution of the provided command
+	r.Add(1)
+	r.started = true
+	go r.run(ctx, r.reason)
+}
+
+// run executes each of the command's steps in order until they have all
+// exited or the context is cancelled
+func (r *Rerun) run(ctx context.Context, reason reason) {
+	log.WithField("command", r.currentCommand()).Debug("Started go routine for new command execution")
+	defer r.Done()
+
+	// Keep a copy of all of the steps' stdout and stderr for LastOutput()
+	stdout := &outputBuffer{mu: &r.mu}
+	stderr := &outputBuffer{mu: &r.mu}
+	// Both streams together for matching Until and While against
+	combined := &outputBuffer{mu: &r.mu}
+
+	// Don't bother starting the command if we were already stopped
+	if ctx.Err() != nil {
+		log.WithField("command", r.currentCommand()).Debug("Command was stopped before it started")
+		return
+	}
+	r.mu.Lock()
+	r.stdout, r.stderr = stdout, stderr
+	r.stepExitCodes = nil
+	r.busy = true
+	r.mu.Unlock()
+	started := time.Now()
+
+	// Say when the command is ready to accept connections
+	stopWaiting := func() {}
+	if r.WaitFor != "" {
+		stopWaiting = r.waitReady(ctx, reason)
+	}
+	defer stopWaiting()
+
+	// Kill the run if it takes longer than the timeout
+	var deadline <-chan time.Time
+	if r.Timeout > 0 {
+		timer := time.NewTimer(r.Timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	// The run's exit code is the first failed step's
+	code := 0
+	var killed error
+	steps, _ := r.stepsFor(reason)
+	for i, step := range steps {
+		stepCode, stopped, timedOut := r.runStep(ctx, step, reason, i == 0, deadline,
+			io.MultiWriter(stdout, combined), io.MultiWriter(stderr, combined))
+		if stopped {
+			cause := context.Cause(ctx)
+			log.WithFields(log.Fields{"command": r.currentCommand(), "reason": cause.Error()}).
+				Infof("Command was stopped since %s", cause)
+			r.mu.Lock()
+			r.busy = false
+			r.mu.Unlock()
+			r.emit(LifecycleEvent{Type: CommandStopped, Reason: cause.Error()})
+			return
+		}
+		r.mu.Lock()
+		r.stepExitCodes = append(r.stepExitCodes, stepCode)
+		r.mu.Unlock()
+		if code == 0 {
+			code = stepCode
+		}
+		if timedOut {
+			killed = ErrTimedOut
+			break
+		}
+		if stepCode != 0 && r.FailFast {
+			break
+		}
+	}
+	stopWaiting()
+	duration := time.Since(started)
+	r.mu.Lock()
+	if code == 0 && r.FailOnStderr && stderr.buf.Len() > 0 {
+		log.WithField("command", r.currentCommand()).Info("Command wrote to stderr, so the run failed")
+		code = 1
+	}
+	// The first run's result is always a change
+	resultChanged := r.stats.Runs == 0 || (r.lastExitCode == 0) != (code == 0)
+	r.lastExitCode = code
+	r.stats.add(code, duration)
+	r.failed = nil
+	if code != 0 {
+		r.failed = &failure{code: code, tail: tailLines(stderr.buf.String(), errorSummaryLines)}
+	}
+	r.mu.Unlock()
+	fields := log.Fields{"command": r.currentCommand(), "exit_code": code, "duration": duration.String()}
+	event := LifecycleEvent{Type: CommandExited, ExitCode: code, Duration: duration}
+	if killed != nil {
+		fields["reason"] = killed.Error()
+		event.Reason = killed.Error()
+	}
+	log.WithFields(fields).Infof("Command exited with status %d", code)
+	r.emit(event)
+	if !r.OnlyOnResultChange || resultChanged {
+		if r.OnlyOnResultChange && r.Banner {
+			r.stdoutLine.endLine()
+			fmt.Fprintln(r.stdoutLine, r.resultBanner(code))
+		}
+		_, stderrOutput := r.LastOutput()
+		r.notify(code, stderrOutput)
+	}
+	r.runHook(ctx, code, reason)
+	if code == 0 && r.WaitFor == "" {
+		r.runReadyHook(reason)
+	}
+
+	// Stop rerunning once the output says to
+	r.mu.Lock()
+	output := combined.buf.String()
+	r.mu.Unlock()
+	if r.outputFinishes(output) {
+		r.finish()
+		return
+	}
+
+	// Run again for anything which changed while the command was running
+	r.mu.Lock()
+	queued := r.queued
+	r.queued = nil
+	r.busy = false
+	if queued != nil && ctx.Err() == nil {
+		log.WithField("command", r.currentCommand()).Info("Rerunning command for changes made while it was running")
+		r.reason = *queued
+		r.backoff = 0
+		r.restarts = 0
+		r.start()
+	}
+	r.mu.Unlock()
+	if queued != nil {
+		return
+	}
+
+	// Bring the command back up if it crashed
+	if code != 0 && r.Keepalive {
+		r.keepalive(ctx)
+	}
+}
+
+// runStep executes a step until it exits, the context is cancelled or the
+// deadline passes, returning its exit code, whether it was stopped and
+// whether it was killed for timing out
+func (r *Rerun) runStep(ctx context.Context, step step, reason reason, first bool, deadline <-chan time.Time,
+	stdout, stderr io.Writer) (int, bool, bool) {
+	cmd := r.command(step, reason)
+	cmd.Env = r.environ(reason)
+	cmd.Dir = r.Dir
+	if r.Stdin {
+		// Since stdin is a file each process inherits it directly rather than
+		// rerun copying input to it, so nothing is left reading after a
+		// restart. The command stays in rerun's process group so it's allowed
+		// to read from the terminal
+		cmd.Stdin = os.Stdin
+	} else {
+		setProcessGroup(cmd)
+	}
+	r.credential.set(cmd)
+	if r.StdinFile != "" {
+		file, err := os.Open(r.StdinFile)
+		if err != nil {
+			log.WithFields(log.Fields{"path": r.StdinFile, "error": err}).
+				Warnf("Unable to open %q for the command's stdin, so it gets none: %q", r.StdinFile, err)
+		} else {
+			defer file.Close()
+			cmd.Stdin = file
+		}
+	}
+
+	// Immediately write out all stdout and stderr from the running command
+	stdoutWriters := []io.Writer{r.prefixed(r.stdoutLine, reason), stdout}
+	stderrWriters := []io.Writer{r.prefixed(r.stderrLine, reason), stderr}
+	if r.logFile != nil {
+		stdoutWriters = append(stdoutWriters, r.logFile)
+		stderrWriters = append(stderrWriters, r.logFile)
+	}
+	cmd.Stdout = io.MultiWriter(stdoutWriters...)
+	cmd.Stderr = io.MultiWriter(stderrWriters...)
+	var tty *pty
+	if r.PTY {
+		var err error
+		if tty, err = openPTY(cmd); err != nil {
+			log.WithFields(log.Fields{"command": step.command, "error": err}).
+				Warnf("Unable to run the command in a pseudo-terminal, so its output is piped: %q", err)
+		} else {
+			defer tty.close()
+		}
+	}
+
+	if ctx.Err() != nil {
+		return 0, true, false
+	}
+	err := cmd.Start()
+	if err != nil {
+		// The deferred close frees the pseudo-terminal without waiting for
+		// output from a command which never ran
+		log.WithFields(log.Fields{"command": step.command, "error": err}).Error("Unable to start command")
+		r.emit(LifecycleEvent{Type: Error, Error: err.Error()})
+		return exitCode(err), false, false
+	}
+	if tty != nil {
+		tty.started(io.MultiWriter(stdoutWriters...))
+	}
+	log.WithField("command", step.command).Debug("Command is running")
+	if r.Nice != 0 {
+		if err := setNice(cmd, r.Nice); err != nil {
+			log.WithFields(log.Fields{"command": step.command, "error": err}).Warnf("Unable to set the command's priority: %q", err)
+		}
+	}
+	r.setRunning(cmd)
+	if first {
+		r.emit(LifecycleEvent{Type: CommandStarted})
+	}
+
+	// Wait for the command to exit, either on its own or because the
+	// context was cancelled
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	timedOut := false
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		r.stopCommand(cmd, done)
+	case <-deadline:
+		log.WithFields(log.Fields{"command": step.command, "reason": ErrTimedOut.Error()}).
+			Warnf("Command didn't finish within %s and was killed", r.Timeout)
+		timedOut = true
+		if err := kill(cmd); err != nil {
+			log.Debugf("Unable to kill the command: %q", err)
+		}
+		err = <-done
+	}
+	r.setRunning(nil)
+	if ctx.Err() != nil {
+		return 0, true, false
+	}
+	code := exitCode(err)
+	if steps, _ := r.stepsFor(reason); len(steps) > 1 {
+		log.WithFields(log.Fields{"command": step.command, "exit_code": code}).Infof("Step exited with status %d", code)
+	}
+	return code, false, timedOut
+}
+
+// keepalive restarts the command after an exponential backoff unless it's
+// stopped in the meantime
+func (r *Rerun) keepalive(ctx context.Context) {
+	r.mu.Lock()
+	if r.backoff == 0 {
+		r.backoff = keepaliveMinBackoff
+	}
+	backoff := r.backoff
+	r.backoff *= 2
+	if r.backoff > keepaliveMaxBackoff {
+		r.backoff = keepaliveMaxBackoff
+	}
+	r.restarts++
+	attempt := r.restarts
+	r.mu.Unlock()
+
+	log.WithFields(log.Fields{"command": r.currentCommand(), "backoff": backoff.String(), "attempt": attempt}).
+		Infof("Restarting command in %s (attempt %d)", backoff, attempt)
+	select {
+	case <-ctx.Done():
+		log.WithField("command", r.currentCommand()).Debug("Command was stopped before it was restarted")
+		return
+	case <-time.After(backoff):
+	}
+
+	// Only restart if nothing else stopped or restarted the command while
+	// we were waiting
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ctx.Err() == nil {
+		r.reason = reason{event: reasonKeepalive}
+		r.start()
+	}
+}
+
+// resetBackoff resets the keepalive backoff and restart attempts
+func (r *Rerun) resetBackoff() {
+	r.mu.Lock()
+	r.backoff = 0
+	r.restarts = 0
+	r.mu.Unlock()
+}
+
+// stopCommand asks the command to exit with SIGTERM and forcibly kills it if
+// it hasn't exited by the end of the grace period. Anything left in the
+// command's process group afterwards, such as children which outlived the
+// shell, is always killed.
+func (r *Rerun) stopCommand(cmd *exec.Cmd, done <-chan error) {
+	exited := false
+	if r.Grace > 0 && r.KillSignal != syscall.SIGKILL {
+		name := "SIGTERM"
+		if r.KillSignal != nil {
+			name = r.KillSignal.String()
+		}
+		log.Debugf("Sending %s to the command", name)
+		err := terminate(cmd, r.KillSignal)
+		if err != nil {
+			log.Debugf("Unable to send %s to the command: %q", name, err)
+		}
+		select {
+		case <-done:
+			exited = true
+		case <-time.After(r.Grace):
+			log.WithField("command", r.currentCommand()).Warnf("Command didn't exit within %s and was force killed", r.Grace)
+		}
+	}
+	err := kill(cmd)
+	if err != nil {
+		log.Debugf("Unable to kill the command: %q", err)
+	}
+	if !exited {
+		<-done
+	}
+}
+
+// setRunning records the running command, nil once it has exited
+func (r *Rerun) setRunning(cmd *exec.Cmd) {
+	r.mu.Lock()
+	r.cmd = cmd
+	if cmd == nil {
+		r.finished = time.Now()
+	}
+	r.mu.Unlock()
+}
+
+// selfChange reports whether a change now was likely made by the command
+// itself, since it's running or has only just exited
+func (r *Rerun) selfChange() bool {
+	settle := r.SelfChangeSettle
+	if settle <= 0 {
+		settle = DefaultSelfChangeSettle
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cmd != nil || time.Since(r.finished) < settle
+}
+
+// Signal sends the signal to the running command. Unlike stopping the command
+// the rest of its process group isn't signalled, since children which don't
+// handle the signal would otherwise exit
+func (r *Rerun) Signal(sig os.Signal) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cmd == nil {
+		return errors.New("The command isn't running")
+	}
+	err := r.cmd.Process.Signal(sig)
+	if err != nil {
+		return fmt.Errorf("Unable to send %s to the command: %q", sig, err)
+	}
+	return nil
+}
+
+// Running reports whether the command is currently running
+func (r *Rerun) Running() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cmd != nil
+}
+
+// LastExitCode returns the exit code of the last run which exited on its own,
+// or -1 if the command couldn't be started
+func (r *Rerun) LastExitCode() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastExitCode
+}
+
+// StepExitCodes returns the exit code of each step of the last run, which
+// stops at the first failure with FailFast
+func (r *Rerun) StepExitCodes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.stepExitCodes...)
+}
+
+// exitCode returns the exit code for the error returned by cmd.Wait()
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// Stop kills the running command and waits for its go routine to end
+func (r *Rerun) Stop() {
+	log.WithField("command", r.currentCommand()).Debug("Called Stop()")
+	r.control.Lock()
+	defer r.control.Unlock()
+	r.stop(ErrStopped)
+}
+
+// stop kills the running command for the cause and waits for its go routine
+// to end, r.control must be held
+func (r *Rerun) stop(cause error) {
+	r.mu.Lock()
+	if r.cancel != nil {
+		r.cancel(cause)
+		r.cancel = nil
+	}
+	r.mu.Unlock()
+	// Wait until go routine has ended before continuing. It only ends once
+	// cmd.Wait() has returned, after the command's stdout and stderr have
+	// been copied, so none of its output can end up in the next run's
+	log.Debug("Waiting for waitgroup to be empty")
+	r.Wait()
+}
+
+// Events returns a channel from filesystem watcher, which is nil when polling
+// for changes
+func (r *Rerun) Events() chan fsnotify.Event {
+	if r.watcher == nil {
+		return nil
+	}
+	return r.watcher.Events
+}
+
+// Run starts the command and reruns it whenever a watched file changes. It
+// blocks until the context is cancelled, then stops the command and the
+// filesystem watcher.
+func (r *Rerun) Run(ctx context.Context) error {
+	defer r.cleanup()
+
+	if r.HTTPAddr != "" {
+		err := r.serveHTTP()
+		if err != nil {
+			return err
+		}
+	}
+	if r.MetricsAddr != "" {
+		err := r.serveMetrics()
+		if err != nil {
+			return err
+		}
+	}
+	if r.Socket != "" {
+		err := r.serveSocket()
+		if err != nil {
+			return err
+		}
+	}
+	if r.Serve != "" {
+		err := r.serveProxy()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Start initial execution of the provided command, after the initial
+	// delay if there is one
+	var initial <-chan time.Time
+	if r.SkipInitialRun {
+		log.Debug("Waiting for the first change before running the command")
+	} else if r.InitialDelay > 0 {
+		log.Debugf("Waiting %s before running the command", r.InitialDelay)
+		initial = time.After(r.InitialDelay)
+	} else {
+		r.Start()
+	}
+
+	// Receives once it's time to restart the command for a burst of events,
+	// nil until an event has been received. Each burst is when the events in
+	// a watched directory started and last arrived, with a single burst for
+	// all of them unless DebouncePerDir
+	var pending <-chan time.Time
+	var bursts map[string]*burst
+	var lastRoot string
+	// Paths which changed during the current burst of events, and the
+	// operation of the most recent change
+	var changed []string
+	var lastOp fsnotify.Op
+	// Restarts are paused after too many within the restart interval
+	limit := newRestartLimit(r.MaxRestarts, r.RestartInterval)
+	var paused bool
+	// When the command was last started, for Throttle
+	var lastRun time.Time
+	if initial == nil && !r.SkipInitialRun {
+		lastRun = time.Now()
+	}
+
+	// Restart the command for the changed paths unless it has been restarted
+	// too often, in which case wait for the changes to stop first
+	rerun := func() {
+		if !limit.allow(time.Now()) {
+			paused = true
+			pending = time.After(limit.interval)
+			log.WithField("command", r.currentCommand()).Warnf("Command was restarted %d times within %s, so restarts are "+
+				"paused until nothing changes for %s. If the command changes files itself, ignore them with --ignore",
+				limit.max, limit.interval, limit.interval)
+			return
+		}
+		lastRun = time.Now()
+		r.restart(reason{event: reasonChange, changed: changed, op: lastOp})
+		changed = nil
+	}
+
+	// Record an event from either the filesystem watcher or a poller,
+	// reporting whether it should cause the command to rerun
+	var lastPath string
+	var lastAt time.Time
+	noteEvent := func(event fsnotify.Event) bool {
+		reruns := false
+		defer func() { r.countEvent(reruns) }()
+		if !r.handleEvent(event) {
+			return false
+		}
+
+		// A single save often sends several events for the same path, which
+		// shouldn't each restart the command
+		now := time.Now()
+		duplicate := event.Name == lastPath && now.Sub(lastAt) < duplicateWindow
+		if !duplicate {
+			lastPath, lastAt = event.Name, now
+		}
+		if duplicate && pending == nil {
+			log.WithFields(log.Fields{"path": event.Name, "op": opName(event.Op)}).
+				Debugf("Dropping duplicate event for %q", event.Name)
+			return false
+		}
+		if r.rule(event.Name).Ignore {
+			log.WithField("path", event.Name).Debugf("Ignoring %q by a rule", event.Name)
+			return false
+		}
+		changed = appendChanged(changed, event.Name)
+		lastOp = event.Op
+		lastRoot = r.root(event.Name)
+		reruns = true
+		return true
+	}
+	// Files already inside a new directory, such as from git checkout or
+	// tar -x, don't send events of their own so note each of them too
+	note := func(event fsnotify.Event) bool {
+		found := noteEvent(event)
+		created := r.created
+		r.created = nil
+		for _, path := range created {
+			if noteEvent(fsnotify.Event{Name: path, Op: fsnotify.Create}) {
+				found = true
+			}
+		}
+		return found
+	}
+
+	// Restart the command once the delay and debounce periods have passed
+	schedule := func() {
+		if initial != nil {
+			// The first run hasn't started yet and will see the changes
+			return
+		}
+		if paused {
+			// Keep waiting until the changes stop
+			pending = time.After(limit.interval)
+			return
+		}
+		now := time.Now()
+		if pending == nil {
+			bursts = make(map[string]*burst)
+		}
+		key := ""
+		if r.DebouncePerDir {
+			key = lastRoot
+		}
+		if bursts[key] == nil {
+			bursts[key] = &burst{first: now}
+		}
+		bursts[key].last = now
+		// Restart for whichever burst is due soonest
+		var at time.Time
+		for _, b := range bursts {
+			if due := r.restartAt(b.first, b.last); at.IsZero() || due.Before(at) {
+				at = due
+			}
+		}
+		wait := at.Sub(now)
+		if throttled := lastRun.Add(r.Throttle).Sub(now); r.Throttle > 0 && throttled > wait {
+			wait = throttled
+		}
+		// A rename is usually a file moving away, which is followed straight
+		// away by the create of the file moved in its place, and both should
+		// only rerun the command once
+		if lastOp&fsnotify.Rename != 0 && wait < renameWindow {
+			wait = renameWindow
+		}
+		if wait > 0 {
+			log.WithFields(log.Fields{"command": r.currentCommand(), "wait": wait.String()}).
+				Debugf("Waiting %s before restarting the command", wait)
+			pending = time.After(wait)
+			return
+		}
+		// A wait for an earlier event is covered by this rerun
+		pending = nil
+		rerun()
+	}
+
+	// Either poll the tree for changes or receive filesystem watcher errors
+	var pollTick <-chan time.Time
+	var watcherErrors chan error
+	// Check for removed roots coming back when using the filesystem watcher
+	var rootTick <-chan time.Time
+	if r.watcher == nil {
+		ticker := time.NewTicker(r.pollInterval())
+		defer ticker.Stop()
+		pollTick = ticker.C
+	} else {
+		watcherErrors = r.watcher.Errors
+		ticker := time.NewTicker(DefaultPollInterval)
+		defer ticker.Stop()
+		rootTick = ticker.C
+	}
+
+	log.Debug("Starting main loop")
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debug("Context was cancelled, exiting main loop")
+			return nil
+		case <-pollTick:
+			// Changes found in the same poll only cause one restart
+			found := false
+			for _, event := range r.pollTree() {
+				if note(event) {
+					found = true
+				}
+			}
+			if found {
+				schedule()
+			}
+		case <-r.matched:
+			log.Debug("Output matched, exiting main loop")
+			return nil
+		case next := <-r.reconfigure:
+			r.reload(next)
+		case <-r.interrupted:
+			log.WithField("signal", r.caught.String()).Debug("Caught a signal, exiting main loop")
+			return &SignalError{Signal: r.caught}
+		case <-rootTick:
+			for root := range r.missingRoots {
+				info, err := os.Stat(root)
+				if err != nil || !info.IsDir() {
+					continue
+				}
+				delete(r.missingRoots, root)
+				log.WithField("path", root).Warnf("Watched directory %q is back and is being watched again", root)
+				// Treat it like a new directory so it's walked and the
+				// command reruns
+				if note(fsnotify.Event{Name: root, Op: fsnotify.Create}) {
+					schedule()
+				}
+			}
+		case err, ok := <-watcherErrors:
+			if !ok {
+				return r.closedErr()
+			}
+			log.WithField("error", err.Error()).Errorf("Filesystem watcher error: %q", err)
+			r.emit(LifecycleEvent{Type: Error, Error: err.Error()})
+		case event, ok := <-r.Events():
+			if !ok {
+				return r.closedErr()
+			}
+			log.WithFields(log.Fields{"path": event.Name, "op": opName(event.Op)}).Debug("Filesystem watcher received an event")
+			if note(event) {
+				schedule()
+			}
+		case event := <-r.poller.events:
+			log.WithFields(log.Fields{"path": event.Name, "op": opName(event.Op)}).Debug("Poller detected a change")
+			if note(event) {
+				schedule()
+			}
+		case <-initial:
+			initial = nil
+			changed = nil
+			lastRun = time.Now()
+			r.Start()
+		case <-r.trigger:
+			log.WithField("command", r.currentCommand()).Debug("Rerunning command since a rerun was requested")
+			initial = nil
+			pending = nil
+			paused = false
+			limit.reset()
+			lastRun = time.Now()
+			r.restart(reason{event: reasonManual, changed: changed, op: lastOp})
+			changed = nil
+		case <-pending:
+			pending = nil
+			if paused {
+				log.WithField("command", r.currentCommand()).Info("Resuming restarts since nothing has changed")
+				paused = false
+				limit.reset()
+			} else {
+				log.Debug("Delay and debounce periods have passed")
+			}
+			rerun()
+		}
+	}
+}
+
+// burst is when a burst of events started and when the latest arrived
+type burst struct {
+	first time.Time
+	last  time.Time
+}
+
+// restartAt returns when to restart the command for a burst of events which
+// started at first and last received an event at last
+func (r *Rerun) restartAt(first, last time.Time) time.Time {
+	at := first.Add(r.Delay)
+	if debounced := last.Add(r.Debounce); debounced.After(at) {
+		at = debounced
+	}
+	return at
+}
+
+// appendChanged adds the path to the list of changed paths, moving it to the
+// end if it's already in the list so the last path is the most recent change
+func appendChanged(changed []string, path string) []string {
+	for i, p := range changed {
+		if p == path {
+			changed = append(changed[:i], changed[i+1:]...)
+			break
+		}
+	}
+	return append(changed, path)
+}
+
+// restart kills the current running command and starts a new execution of
+// it for the reason, resetting the keepalive backoff, unless ReloadSignal or
+// the rules for the changed paths signal it instead
+func (r *Rerun) restart(reason reason) {
+	if changed := reason.changed; reason.event == reasonChange && len(changed) > 0 {
+		log.WithFields(log.Fields{"command": r.currentCommand(), "path": changed[len(changed)-1], "changed": len(changed)}).
+			Infof("Rerunning command since %q changed", changed[len(changed)-1])
+	}
+	// Signal the command instead when the rules say so for every change
+	var signals []os.Signal
+	if reason.event == reasonChange && len(reason.changed) > 0 {
+		signals = r.signalsFor(reason.changed)
+	} else if r.ReloadSignal != nil {
+		signals = []os.Signal{r.ReloadSignal}
+	}
+	if len(signals) > 0 {
+		sent := true
+		for _, sig := range signals {
+			if err := r.Signal(sig); err != nil {
+				sent = false
+				break
+			}
+			log.WithField("command", r.currentCommand()).Infof("Sent %s to the command", sig)
+		}
+		if sent {
+			return
+		}
+		log.Debug("Restarting the command since it isn't running to signal")
+	}
+	// Let the current run finish first with NoKillOnChange
+	if r.NoKillOnChange && reason.event == reasonChange && r.queue(reason) {
+		log.WithField("command", r.currentCommand()).Info("Rerunning command once it finishes")
+		return
+	}
+	r.restartFor(reason)
+}
+
+// queue saves the reason to rerun the command for once the current run
+// finishes, reporting whether there is one
+func (r *Rerun) queue(reason reason) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.busy {
+		return false
+	}
+	if r.queued != nil {
+		// Keep the changes which were already waiting for the run
+		changed := r.queued.changed
+		for _, path := range reason.changed {
+			changed = appendChanged(changed, path)
+		}
+		reason.changed = changed
+	}
+	r.queued = &reason
+	return true
+}
+
+// Restart stops the running command and starts it again, resetting the
+// keepalive backoff. It blocks until the old process has exited
+func (r *Rerun) Restart() {
+	log.WithField("command", r.currentCommand()).Debug("Called Restart()")
+	r.restartFor(reason{event: reasonManual})
+}
+
+// restartFor stops the running command and starts it again for the reason
+// without another Start() or Stop() happening in between
+func (r *Rerun) restartFor(reason reason) {
+	r.control.Lock()
+	defer r.control.Unlock()
+	r.stop(stopCause(reason))
+	r.waitRestartDelay()
+	r.resetBackoff()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reason = reason
+	r.start()
+}
+
+// waitRestartDelay waits for RestartDelay between stopping and starting the
+// command, unless rerun is interrupted in the meantime
+func (r *Rerun) waitRestartDelay() {
+	if r.RestartDelay <= 0 {
+		return
+	}
+	log.WithField("command", r.currentCommand()).Debugf("Waiting %s before starting the command again", r.RestartDelay)
+	select {
+	case <-time.After(r.RestartDelay):
+	case <-r.interrupted:
+	}
+}
+
+// outputFinishes reports whether a run's output means the command shouldn't be
+// rerun because of Until or While
+func (r *Rerun) outputFinishes(output string) bool {
+	if r.Until != nil && r.Until.MatchString(output) {
+		log.Infof("Output matched %q, so the command won't be rerun", r.Until)
+		return true
+	}
+	if r.While != nil && !r.While.MatchString(output) {
+		log.Infof("Output didn't match %q, so the command won't be rerun", r.While)
+		return true
+	}
+	return false
+}
+
+// finish stops the main loop
+func (r *Rerun) finish() {
+	r.matchedOnce.Do(func() {
+		close(r.matched)
+	})
+}
+
+// RequestRerun asks Run() to rerun the command, the same as POST /rerun
+func (r *Rerun) RequestRerun() {
+	log.WithField("command", r.currentCommand()).Debug("Called RequestRerun()")
+	r.requestRerun()
+}
+
+// requestRerun asks the main loop to restart the command. A restart which is
+// already requested covers this one too
+func (r *Rerun) requestRerun() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// closedErr returns the error for the filesystem watcher being closed, which
+// is expected when rerun is exiting
+func (r *Rerun) closedErr() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.exiting {
+		return nil
+	}
+	return errors.New("filesystem watcher was closed")
+}
+
+// handleEvent updates the watch list for a filesystem event and reports
+// whether the event should cause the command to rerun
+func (r *Rerun) handleEvent(event fsnotify.Event) bool {
+	fields := log.Fields{"event": event.Op.String(), "op": opName(event.Op), "path": event.Name}
+	log.WithFields(fields).Debug("File system event")
+
+	// Changes to the watch list change what's watched rather than rerunning
+	if r.watchFrom != "" && event.Name == r.watchFrom {
+		if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+			r.reloadWatchList()
+		}
+		return false
+	}
+
+	// Only the watched files matter in the directories containing them
+	watchFile := r.watchFiles[event.Name]
+	if !watchFile && r.root(event.Name) == "" {
+		log.WithFields(fields).Debug("Ignoring event for a file which isn't watched")
+		return false
+	}
+
+	// Start watching directories left for Since next to the change
+	r.watchStale(filepath.Dir(event.Name))
+
+	// Add new directories to watch list, walking them since they may have
+	// been created along with sub directories
+	if event.Op&fsnotify.Create == fsnotify.Create {
+		fileInfo, err := os.Stat(event.Name)
+		if err != nil {
+			log.Errorf("Unable to get filesystem info about %q", event.Name)
+		} else if fileInfo.IsDir() {
+			err = filepath.Walk(event.Name, func(path string, f os.FileInfo, err error) error {
+				if f != nil && !f.IsDir() {
+					r.created = append(r.created, path)
+				}
+				return r.WatchDir(path, f, err)
+			})
+			if err != nil {
+				log.Debugf("Unable to walk %q: %q", event.Name, err)
+			}
+		}
+	}
+
+	// Try to remove deleted and moved directories from the watch list. If
+	// they come back the event for their parent directory watches them again,
+	// but nothing watches the parent of a root so wait for it to come back
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		r.UnwatchDir(event.Name)
+		if contains(r.roots, event.Name) && !r.missingRoots[event.Name] {
+			log.Warnf("Watched directory %q was removed, waiting for it to come back", event.Name)
+			r.missingRoots[event.Name] = true
+		}
+	}
+
+	// Reload .gitignore files when they change
+	if filepath.Base(event.Name) == ".gitignore" {
+		r.loadGitignore(filepath.Dir(event.Name))
+	}
+
+	// Directories watched for the Watch patterns only rerun the command for
+	// paths matching them
+	if !watchFile && r.patternRoot(event.Name) && !r.matchesPattern(event.Name) {
+		log.WithFields(fields).Debug("Ignoring event for a path which doesn't match a watch pattern")
+		return false
+	}
+
+	// Directories skipped for IgnoreDirs aren't watched, but may be polled or
+	// contain watched files
+	if r.inIgnoredDir(event.Name) {
+		log.WithFields(fields).Debug("Ignoring event in an ignored directory")
+		return false
+	}
+
+	// Only rerun the command for the filtered operations
+	if r.Ops != 0 && event.Op&r.Ops == 0 {
+		log.WithFields(fields).Debug("Ignoring event for an operation which isn't filtered")
+		return false
+	}
+
+	// Don't rerun the command for ignored paths
+	if watchFile {
+		// Watched files always rerun the command
+	} else if r.Ignored(event.Name) {
+		log.WithFields(fields).Debug("Ignoring event")
+		return false
+	} else if !r.Included(event.Name) && !r.extraRoots[r.root(event.Name)] {
+		log.WithFields(fields).Debug("Event doesn't match an include pattern")
+		return false
+	}
+
+	if r.IgnoreSelfChanges && r.selfChange() {
+		log.WithFields(fields).Debug("Ignoring event since the command probably caused it")
+		return false
+	}
+
+	log.WithFields(fields).Debug("File changed")
+	r.emit(LifecycleEvent{Type: FileChanged, Path: event.Name})
+	return true
+}
+
+// NewRerun returns a rerun for the config which is watching the configured
+// directory for changes
+func NewRerun(config Config) (*Rerun, error) {
+	log.Debug("Called NewRerun()")
+	rerun, err := newRerun(config)
+	if err != nil {
+		return nil, err
+	}
+
+	fileDirs, err := rerun.resolvePaths()
+	if err != nil {
+		return nil, err
+	}
+	rerun.logConfig()
+	err = rerun.openLogFile()
+	if err != nil {
+		return nil, err
+	}
+
+	// Setup a filesystem watcher to detect new files, directories, and
+	// changes, unless we're polling the tree for changes instead
+	if rerun.pollInterval() == 0 {
+		rerun.watcher, err = fsnotify.NewWatcher()
+		if err != nil {
+			return nil, fmt.Errorf("Filesystem watcher error: %q", err)
+		}
+	}
+	// Setup a poller for directories which can't be watched
+	rerun.poller = newPoller(DefaultPollInterval)
+
+	rerun.walk(fileDirs)
+	if rerun.watcher == nil {
+		log.Debugf("Polling for changes every %s", rerun.pollInterval())
+		rerun.files = rerun.snapshot()
+	}
+
+	rerun.handleSignals()
+	return rerun, nil
+}
+
+// logConfig logs how the config was resolved, with what's needed to reproduce
+// a setup at info level and the rest at debug level
+func (r *Rerun) logConfig() {
+	shell := r.currentShell()
+	if shell == "" {
+		shell = DefaultShell
+	}
+	if r.NoShell || len(r.Args) > 0 {
+		shell = "none"
+	}
+	fields := log.Fields{
+		"command":  r.currentCommand(),
+		"shell":    shell,
+		"dirs":     strings.Join(r.roots, ","),
+		"debounce": r.Debounce.String(),
+		"delay":    r.Delay.String(),
+	}
+	// Leave out the patterns which aren't used to keep it short
+	for name, patterns := range map[string][]string{
+		"files": r.Files, "watch": r.Watch, "ignore": r.Ignore, "ignore_dirs": r.IgnoreDirs, "include": r.Include,
+	} {
+		if len(patterns) > 0 {
+			fields[name] = strings.Join(patterns, ",")
+		}
+	}
+	if r.WatchFrom != "" {
+		fields["watch_from"] = r.WatchFrom
+	}
+	log.WithFields(fields).Info("Resolved the config")
+
+	var rules, maps []string
+	for _, rule := range r.Rules {
+		action := "restart"
+		if rule.Ignore {
+			action = "ignore"
+		} else if rule.Signal != nil {
+			action = "signal:" + rule.Signal.String()
+		}
+		rules = append(rules, rule.Pattern+"="+action)
+	}
+	for _, m := range r.CommandMaps {
+		maps = append(maps, m.Pattern+"="+m.Command)
+	}
+	log.WithFields(log.Fields{
+		"cwd":             r.Dir,
+		"rules":           strings.Join(rules, ","),
+		"maps":            strings.Join(maps, ","),
+		"hidden":          r.Hidden,
+		"no_gitignore":    r.NoGitignore,
+		"follow_symlinks": r.FollowSymlinks,
+		"no_recursive":    r.NoRecursive,
+		"recursive_depth": r.RecursiveDepth,
+		"poll":            r.pollInterval().String(),
+		"throttle":        r.Throttle.String(),
+		"timeout":         r.Timeout.String(),
+		"grace":           r.Grace.String(),
+		"restart_delay":   r.RestartDelay.String(),
+		"keepalive":       r.Keepalive,
+	}).Debug("Resolved the rest of the config")
+}
+
+// resolvePaths sets the absolute roots and files to watch, returning the
+// directories containing the files
+func (r *Rerun) resolvePaths() ([]string, error) {
+	// Default to watching the current directory
+	dirs := r.Dirs
+	if len(dirs) == 0 && len(r.Files) == 0 && len(r.Watch) == 0 && r.WatchFrom == "" {
+		curDir, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to determine current directory: %q", err)
+		}
+		dirs = []string{curDir}
+	}
+	for _, dir := range dirs {
+		root, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to determine absolute path of %q: %q", dir, err)
+		}
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to watch %q: %q", dir, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("Unable to watch %q: not a directory", dir)
+		}
+		r.roots = append(r.roots, root)
+	}
+	// Extra directories are watched like Dirs and extra files like Files
+	files := r.Files
+	for _, extra := range r.AlsoWatch {
+		path, err := filepath.Abs(extra)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to determine absolute path of %q: %q", extra, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to watch %q: %q", extra, err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		if !contains(r.roots, path) {
+			r.roots = append(r.roots, path)
+		}
+		r.extraRoots[path] = true
+	}
+	var fileDirs []string
+	for _, file := range files {
+		path, err := filepath.Abs(file)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to determine absolute path of %q: %q", file, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to watch %q: %q", file, err)
+		}
+		if info.IsDir() {
+			return nil, fmt.Errorf("Unable to watch %q: is a directory", file)
+		}
+		if !r.watchFiles[path] && !contains(fileDirs, filepath.Dir(path)) {
+			fileDirs = append(fileDirs, filepath.Dir(path))
+		}
+		r.watchFiles[path] = true
+	}
+	if err := r.resolvePatterns(); err != nil {
+		return nil, err
+	}
+	if r.WatchFrom != "" {
+		if err := r.resolveWatchList(); err != nil {
+			return nil, err
+		}
+		if dir := filepath.Dir(r.watchFrom); !contains(fileDirs, dir) {
+			fileDirs = append(fileDirs, dir)
+		}
+	}
+	return fileDirs, nil
+}
+
+// walk watches the roots and their sub directories, and the directories
+// containing watched files
+func (r *Rerun) walk(fileDirs []string) {
+	log.Debug("Finding sub directories to watch for changes")
+	// Walk through file system to watch sub directories, or just the roots
+	// with WatchNewOnly unless the whole tree is polled
+	r.lazy = r.WatchNewOnly && r.pollInterval() == 0
+	defer func() { r.lazy = false }()
+	for _, root := range r.roots {
+		r.loadParentGitignores(root)
+		err := filepath.Walk(root, r.WatchDir)
+		if err != nil {
+			log.Debugf("Unable to walk %q: %q", root, err)
+		}
+	}
+	// Watch the directories containing files rather than the files
+	// themselves, since editors which save by replacing the file would leave
+	// a watch on the file watching nothing
+	for _, dir := range fileDirs {
+		r.WatchFileDir(dir)
+	}
+}
+
+// Reasons directories aren't watched, for ListedDir
+const (
+	SkippedGit          = "git"
+	SkippedHidden       = "hidden"
+	SkippedIgnore       = "ignore"
+	SkippedIgnoreDir    = "ignore-dir"
+	SkippedEditor       = "editor"
+	SkippedGitignore    = "gitignore"
+	SkippedWatchPattern = "watch-pattern"
+	SkippedDepth        = "depth"
+	SkippedSince        = "since"
+	SkippedWatchNewOnly = "watch-new-only"
+)
+
+// ListedDir is a directory found while walking the watched directories and
+// whether it would be watched, or the reason it wouldn't be
+type ListedDir struct {
+	Path    string `json:"path"`
+	Watched bool   `json:"watched"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// ListDirs returns the directories which would be watched for the config,
+// without watching them or running the command
+func ListDirs(config Config) ([]string, error) {
+	listed, err := ListAllDirs(config)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, dir := range listed {
+		if dir.Watched {
+			dirs = append(dirs, dir.Path)
+		}
+	}
+	return dirs, nil
+}
+
+// ListAllDirs returns the directories which would be watched for the config
+// along with those which would be skipped, without watching them or running
+// the command. Nothing inside skipped directories is listed apart from with
+// Since, where they're still walked
+func ListAllDirs(config Config) ([]ListedDir, error) {
+	rerun, err := newRerun(config)
+	if err != nil {
+		return nil, err
+	}
+	fileDirs, err := rerun.resolvePaths()
+	if err != nil {
+		return nil, err
+	}
+	rerun.listing = true
+	rerun.walk(fileDirs)
+	return rerun.listed, nil
+}
+
+// list records the directory for ListAllDirs, which is watched unless there's
+// a reason it's skipped
+func (r *Rerun) list(path, reason string) {
+	if r.listing {
+		r.listed = append(r.listed, ListedDir{Path: path, Watched: reason == "", Reason: reason})
+	}
+}
+
+// RunOnce runs the command a single time without watching for changes and
+// returns its exit code
+func RunOnce(config Config) (int, error) {
+	log.Debug("Called RunOnce()")
+	config.Keepalive = false
+	rerun, err := newRerun(config)
+	if err != nil {
+		return 0, err
+	}
+	err = rerun.openLogFile()
+	if err != nil {
+		return 0, err
+	}
+	rerun.handleSignals()
+	defer rerun.stopSignals()
+	defer rerun.closeLogFile()
+
+	if rerun.InitialDelay > 0 {
+		log.Debugf("Waiting %s before running the command", rerun.InitialDelay)
+		select {
+		case <-time.After(rerun.InitialDelay):
+		case <-rerun.interrupted:
+			return signalExitCode(rerun.caught), &SignalError{Signal: rerun.caught}
+		}
+	}
+	rerun.Start()
+
+	// Stop the command when a signal is caught
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-rerun.interrupted:
+			rerun.cleanup()
+		case <-done:
+		}
+	}()
+	rerun.Wait()
+	select {
+	case <-rerun.interrupted:
+		rerun.cleanup()
+		return signalExitCode(rerun.caught), &SignalError{Signal: rerun.caught}
+	default:
+	}
+	return rerun.LastExitCode(), nil
+}
+
+// newRerun returns a rerun for the config which isn't watching anything yet
+func newRerun(config Config) (*Rerun, error) {
+	var err error
+	var rerun Rerun
+	rerun.Config = config
+	rerun.lifecycle = make(chan LifecycleEvent, lifecycleBuffer)
+	rerun.gitignores = make(map[string][]gitignorePattern)
+	rerun.trigger = make(chan struct{}, 1)
+	rerun.reconfigure = make(chan reloaded, 1)
+	rerun.matched = make(chan struct{})
+	rerun.interrupted = make(chan struct{})
+	rerun.dirs = make(map[interface{}]string)
+	rerun.watched = make(map[string]bool)
+	rerun.watchFiles = make(map[string]bool)
+	rerun.missingRoots = make(map[string]bool)
+	rerun.stale = make(map[string][]string)
+	rerun.patternRoots = make(map[string]bool)
+	rerun.extraRoots = make(map[string]bool)
+	rerun.stdoutLine = &lineWriter{w: os.Stdout}
+	if config.Stdout != nil {
+		rerun.stdoutLine.w = config.Stdout
+	}
+	rerun.stderrLine = &lineWriter{w: os.Stderr}
+	if config.Stderr != nil {
+		rerun.stderrLine.w = config.Stderr
+	}
+
+	// Make sure the command's working directory exists before running it
+	dir := config.Dir
+	if dir == "" {
+		dir, err = os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to determine current directory: %q", err)
+		}
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to determine absolute path of %q: %q", config.Dir, err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to run the command in %q: %q", config.Dir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("Unable to run the command in %q: not a directory", config.Dir)
+	}
+	rerun.Dir = dir
+	log.WithField("dir", dir).Infof("Running the command in %q", dir)
+
+	// Make sure the command can be run at the priority
+	if config.Nice != 0 {
+		if err := checkNice(config.Nice); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.PTY && !ptySupported {
+		log.Warn("Pseudo-terminals are only supported on Linux, so the command's output is piped")
+		rerun.PTY = false
+	}
+
+	// Make sure the command can be run as the user and group
+	if config.User != "" || config.Group != "" {
+		rerun.credential, err = resolveCredential(config.User, config.Group)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Split the commands up front so a bad command fails immediately
+	rerun.steps, rerun.Command, err = parseSteps(config)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range config.CommandMaps {
+		steps, _, err := parseSteps(Config{Command: m.Command, NoShell: config.NoShell})
+		if err != nil {
+			return nil, err
+		}
+		rerun.mapSteps = append(rerun.mapSteps, steps[0])
+	}
+
+	return &rerun, nil
+}
+
+// parseSteps splits the config's commands into the steps of each run,
+// returning them along with the command to display
+func parseSteps(config Config) ([]step, string, error) {
+	// Only the CommandMaps' commands run without a usual command
+	if config.Command == "" && len(config.Args) == 0 && len(config.Commands) == 0 && len(config.CommandMaps) > 0 {
+		return nil, "", nil
+	}
+	if len(config.Args) > 0 {
+		steps := []step{{command: quoteArgs(config.Args), argv: config.Args}}
+		if config.Command == "" {
+			return steps, steps[0].command, nil
+		}
+		return steps, config.Command, nil
+	}
+	display := config.Command
+	commands := config.Commands
+	if len(commands) == 0 {
+		commands = []string{config.Command}
+	} else if display == "" {
+		display = strings.Join(commands, " && ")
+	}
+	var steps []step
+	for _, command := range commands {
+		step := step{command: command}
+		if config.NoShell {
+			var err error
+			step.argv, err = splitArgs(command)
+			if err != nil {
+				return nil, "", fmt.Errorf("Unable to parse command %q: %s", command, err)
+			}
+			if len(step.argv) == 0 {
+				return nil, "", errors.New("You must provide a command to run")
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps, display, nil
+}
+
+// signals returns the signals rerun catches, which stop the command and exit
+// apart from SIGHUP with RestartOnSIGHUP or ReloadConfig
+func (r *Rerun) signals() []os.Signal {
+	signals := []os.Signal{os.Interrupt, syscall.SIGTERM}
+	if r.RestartOnSIGHUP || r.ReloadConfig != nil {
+		signals = append(signals, syscall.SIGHUP)
+	}
+	return signals
+}
+
+// signalExitCode returns the exit code for exiting because of the signal,
+// which is 128 plus the signal's number like a shell uses, so 130 for SIGINT
+// and 143 for SIGTERM
+func signalExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
+
+// SignalError is returned by Run() and RunOnce() when they stopped because
+// rerun caught SIGINT or SIGTERM
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (e *SignalError) Error() string {
+	return fmt.Sprintf("Stopped by %s", e.Signal)
+}
+
+// ExitCode returns the exit code for exiting because of the signal
+func (e *SignalError) ExitCode() int {
+	return signalExitCode(e.Signal)
+}
+
+// handleSignals catches ctrl+c so Run() or RunOnce() kills the current running
+// command cleanly and returns. A second ctrl+c exits straight away
+func (r *Rerun) handleSignals() {
+	r.signalCh = make(chan os.Signal, 1)
+	signal.Notify(r.signalCh, r.signals()...)
+	go func(c chan os.Signal) {
+		for sig := range c {
+			if sig == syscall.SIGHUP && r.RestartOnSIGHUP {
+				log.Info("Rerunning command since SIGHUP was received")
+				r.requestRerun()
+				continue
+			}
+			if sig == syscall.SIGHUP {
+				r.reloadConfig()
+				continue
+			}
+			if r.interrupt(sig) {
+				continue
+			}
+			log.WithField("signal", sig.String()).Warn("Exiting immediately since another signal was received")
+			os.Exit(signalExitCode(sig))
+		}
+	}(r.signalCh)
+}
+
+// interrupt records the caught signal and reports whether it's the first
+func (r *Rerun) interrupt(sig os.Signal) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.caught != nil {
+		return false
+	}
+	log.WithField("signal", sig.String()).Debugf("Caught %s, stopping", sig)
+	r.caught = sig
+	close(r.interrupted)
+	return true
+}
+
+// cleanup will stop a running command, wait for waitgroups to close and stop
+// the filesystem watcher
+func (r *Rerun) cleanup() {
+	log.Debug("Called cleanup()")
+	// Both Run() and the signal handler clean up, so only the first does
+	// and any other waits for it to finish
+	r.cleanupOnce.Do(func() {
+		// Prevent any new executions of the command before stopping the
+		// current one
+		r.mu.Lock()
+		r.exiting = true
+		r.mu.Unlock()
+		r.control.Lock()
+		r.stop(ErrShutdown)
+		r.control.Unlock()
+		r.logStats()
+		r.closeHTTP()
+		r.closeMetrics()
+		r.closeSocket()
+		r.closeProxy()
+		if r.watcher != nil {
+			log.Debug("Stopping the filesystem watcher")
+			r.watcher.Close()
+		}
+		if r.poller != nil {
+			r.poller.close()
+		}
+		r.closeLogFile()
+		// Stop handling signals last so another one still exits immediately
+		// while cleaning up
+		r.stopSignals()
+	})
+}
+
+// stopSignals stops handling signals, which also ends the signal handler's go
+// routine
+func (r *Rerun) stopSignals() {
+	r.stopSignalsOnce.Do(func() {
+		if r.signalCh != nil {
+			signal.Stop(r.signalCh)
+			close(r.signalCh)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package rerun
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Rule decides what happens when a path matching Pattern changes. The command
+// is restarted unless Ignore or Signal is set
+type Rule struct {
+	Pattern string
+	// Ignore doesn't do anything for the change
+	Ignore bool
+	// Signal is sent to the running command instead of restarting it
+	Signal os.Signal
+}
+
+// ParseRule parses a rule written as <glob>=<action>, where the action is
+// restart, ignore or signal:<name> such as signal:HUP
+func ParseRule(rule string) (Rule, error) {
+	i := strings.LastIndex(rule, "=")
+	if i <= 0 {
+		return Rule{}, fmt.Errorf("Rule %q isn't <glob>=<action>", rule)
+	}
+	parsed := Rule{Pattern: rule[:i]}
+	if _, err := filepath.Match(parsed.Pattern, ""); err != nil {
+		return Rule{}, fmt.Errorf("Invalid pattern in rule %q", rule)
+	}
+	action := rule[i+1:]
+	switch {
+	case action == "restart":
+	case action == "ignore":
+		parsed.Ignore = true
+	case strings.HasPrefix(action, "signal:"):
+		sig, err := ParseSignal(strings.TrimPrefix(action, "signal:"))
+		if err != nil {
+			return Rule{}, err
+		}
+		parsed.Signal = sig
+	default:
+		return Rule{}, fmt.Errorf("Unknown action %q in rule %q", action, rule)
+	}
+	return parsed, nil
+}
+
+// CommandMap runs Command instead of the usual command for changes to paths
+// matching Pattern
+type CommandMap struct {
+	Pattern string
+	Command string
+}
+
+// ParseCommandMap parses a command map written as <glob>=<command>, split at
+// the first = since the command may contain more
+func ParseCommandMap(value string) (CommandMap, error) {
+	i := strings.Index(value, "=")
+	if i <= 0 || i == len(value)-1 {
+		return CommandMap{}, fmt.Errorf("Map %q isn't <glob>=<command>", value)
+	}
+	parsed := CommandMap{Pattern: value[:i], Command: value[i+1:]}
+	if _, err := filepath.Match(parsed.Pattern, ""); err != nil {
+		return CommandMap{}, fmt.Errorf("Invalid pattern in map %q", value)
+	}
+	return parsed, nil
+}
+
+// stepsFor returns the steps to run for the reason, which are the command of
+// the first CommandMap matching the most recently changed path, or otherwise
+// the usual command's. There may be none without a usual command
+func (r *Rerun) stepsFor(reason reason) ([]step, string) {
+	if len(reason.changed) > 0 {
+		path := reason.changed[len(reason.changed)-1]
+		for i, m := range r.CommandMaps {
+			if r.matches([]string{m.Pattern}, path) {
+				return []step{r.mapSteps[i]}, m.Command
+			}
+		}
+	}
+	r.commandMu.RLock()
+	defer r.commandMu.RUnlock()
+	return r.steps, r.Command
+}
+
+// rule returns the first rule matching the path, or the default of sending
+// ReloadSignal or restarting the command
+func (r *Rerun) rule(path string) Rule {
+	for _, rule := range r.Rules {
+		if r.matches([]string{rule.Pattern}, path) {
+			return rule
+		}
+	}
+	return Rule{Signal: r.ReloadSignal}
+}
+
+// signalsFor returns the signals to send the command for the changed paths
+// instead of restarting it, or nil if any of them needs a restart
+func (r *Rerun) signalsFor(changed []string) []os.Signal {
+	var signals []os.Signal
+	for _, path := range changed {
+		rule := r.rule(path)
+		if rule.Ignore {
+			continue
+		}
+		if rule.Signal == nil {
+			return nil
+		}
+		if !containsSignal(signals, rule.Signal) {
+			signals = append(signals, rule.Signal)
+		}
+	}
+	return signals
+}
+
+// containsSignal reports whether the signal is in the list
+func containsSignal(signals []os.Signal, sig os.Signal) bool {
+	for _, s := range signals {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package rerun
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// eventSocket streams lifecycle events to each connection to a Unix socket
+type eventSocket struct {
+	listener net.Listener
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+}
+
+// socketEvent is a lifecycle event as it's written to the socket
+type socketEvent struct {
+	Type     LifecycleType `json:"type"`
+	Time     time.Time     `json:"time"`
+	Command  string        `json:"command"`
+	Path     string        `json:"path,omitempty"`
+	ExitCode *int          `json:"exit_code,omitempty"`
+	// Duration is in seconds
+	Duration float64 `json:"duration,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	Reason   string  `json:"reason,omitempty"`
+}
+
+// newSocketEvent returns the lifecycle event as it's written to the socket
+func newSocketEvent(event LifecycleEvent) socketEvent {
+	written := socketEvent{
+		Type:    event.Type,
+		Time:    event.Time,
+		Command: event.Command,
+		Path:    event.Path,
+		Error:   event.Error,
+		Reason:  event.Reason,
+	}
+	if event.Type == CommandExited {
+		code := event.ExitCode
+		written.ExitCode = &code
+		written.Duration = event.Duration.Seconds()
+	}
+	return written
+}
+
+// serveSocket starts listening on the Socket path
+func (r *Rerun) serveSocket() error {
+	// Replace a socket left behind by a rerun which didn't exit cleanly
+	if info, err := os.Lstat(r.Socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(r.Socket)
+	}
+	listener, err := net.Listen("unix", r.Socket)
+	if err != nil {
+		return fmt.Errorf("Unable to listen on %s: %q", r.Socket, err)
+	}
+	r.socket = &eventSocket{listener: listener, conns: make(map[net.Conn]bool)}
+
+	log.Debugf("Streaming events on %s", r.Socket)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.streamEvents(conn)
+		}
+	}()
+	return nil
+}
+
+// streamEvents writes each lifecycle event to the connection until it's
+// closed
+func (r *Rerun) streamEvents(conn net.Conn) {
+	r.socket.mu.Lock()
+	r.socket.conns[conn] = true
+	r.socket.mu.Unlock()
+	events := r.subscribe()
+	defer func() {
+		r.unsubscribe(events)
+		r.socket.mu.Lock()
+		delete(r.socket.conns, conn)
+		r.socket.mu.Unlock()
+		conn.Close()
+	}()
+
+	// Notice the connection being closed by the other end
+	closed := make(chan struct{})
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+
+	encoder := json.NewEncoder(conn)
+	encoder.SetEscapeHTML(false)
+	for {
+		select {
+		case <-closed:
+			return
+		case event := <-events:
+			if err := encoder.Encode(newSocketEvent(event)); err != nil {
+				log.Debugf("Unable to write event to the socket: %q", err)
+				return
+			}
+		}
+	}
+}
+
+// closeSocket stops listening on the socket, which removes it, and closes
+// each connection
+func (r *Rerun) closeSocket() {
+	if r.socket == nil {
+		return
+	}
+	log.Debug("Closing the event socket")
+	r.socket.listener.Close()
+	r.socket.mu.Lock()
+	defer r.socket.mu.Unlock()
+	for conn := range r.socket.conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,90 @@
+package rerun
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Stats counts the runs of the command which exited on its own and how long
+// they took
+type Stats struct {
+	Runs      int
+	Successes int
+	Failures  int
+	// Total is how long all of the runs took together
+	Total time.Duration
+	// Events are the filesystem events received, of which Ignored didn't
+	// rerun the command
+	Events  int
+	Ignored int
+	// durations counts the runs in each of durationBuckets
+	durations [len(durationBuckets)]int
+}
+
+// Average returns how long a run took on average
+func (s Stats) Average() time.Duration {
+	if s.Runs == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Runs)
+}
+
+// add counts a run which exited with the code after the duration
+func (s *Stats) add(code int, duration time.Duration) {
+	s.Runs++
+	if code == 0 {
+		s.Successes++
+	} else {
+		s.Failures++
+	}
+	s.Total += duration
+	for i, bound := range durationBuckets {
+		if duration.Seconds() <= bound {
+			s.durations[i]++
+			break
+		}
+	}
+}
+
+// countEvent counts a filesystem event, which is ignored unless it reruns the
+// command
+func (r *Rerun) countEvent(reruns bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.Events++
+	if !reruns {
+		r.stats.Ignored++
+	}
+}
+
+// Stats returns the counts and durations of the command's runs so far
+func (r *Rerun) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// logStats prints a summary of the command's runs with Summary, or otherwise
+// logs it
+func (r *Rerun) logStats() {
+	stats := r.Stats()
+	if stats.Runs == 0 {
+		return
+	}
+	summary := fmt.Sprintf("Ran the command %d times, %d succeeded and %d failed, taking %s on average",
+		stats.Runs, stats.Successes, stats.Failures, stats.Average().Round(time.Millisecond))
+	if r.Summary {
+		r.stderrLine.endLine()
+		fmt.Fprintln(r.stderrLine, "rerun: "+summary)
+		return
+	}
+	log.WithFields(log.Fields{
+		"command":   r.currentCommand(),
+		"runs":      stats.Runs,
+		"successes": stats.Successes,
+		"failures":  stats.Failures,
+		"average":   stats.Average().String(),
+	}).Info(summary)
+}
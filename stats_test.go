@@ -0,0 +1,63 @@
+package rerun
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatsAverage(t *testing.T) {
+	var stats Stats
+	if average := stats.Average(); average != 0 {
+		t.Errorf("Average() = %s without any runs, want 0", average)
+	}
+	stats.add(0, time.Second)
+	stats.add(1, 3*time.Second)
+	stats.add(0, 2*time.Second)
+	if stats.Runs != 3 || stats.Successes != 2 || stats.Failures != 1 {
+		t.Errorf("Stats = %+v, want 3 runs with 2 successes and 1 failure", stats)
+	}
+	if average := stats.Average(); average != 2*time.Second {
+		t.Errorf("Average() = %s, want 2s", average)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	dir := t.TempDir()
+	config := testConfig(t, dir, "[ ! -e fail ]")
+	config.Summary = true
+	stderr := &syncBuffer{}
+	config.Stderr = stderr
+	r := newTestRerun(t, config)
+
+	r.Start()
+	waitRun(t, r)
+	writeFile(t, dir, "fail")
+	r.Restart()
+	waitRun(t, r)
+	r.cleanup()
+
+	stats := r.Stats()
+	want := "rerun: Ran the command 2 times, 1 succeeded and 1 failed, taking " +
+		stats.Average().Round(time.Millisecond).String() + " on average\n"
+	if got := stderr.String(); got != want {
+		t.Errorf("Printed %q on exit, want %q", got, want)
+	}
+	if stats.Total <= 0 {
+		t.Errorf("Runs weren't timed: %+v", stats)
+	}
+}
+
+func TestSummaryIsLoggedWithoutSummary(t *testing.T) {
+	dir := t.TempDir()
+	config := testConfig(t, dir, "true")
+	stderr := &syncBuffer{}
+	config.Stderr = stderr
+	r := newTestRerun(t, config)
+
+	r.Start()
+	waitRun(t, r)
+	r.cleanup()
+	if got := stderr.String(); got != "" {
+		t.Errorf("Printed %q on exit without Summary", got)
+	}
+}
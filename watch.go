@@ -0,0 +1,413 @@
+package rerun
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EditorIgnore are the swap, backup and temporary files editors write while
+// saving, which are ignored unless NoEditorIgnore is set
+var EditorIgnore = []string{
+	// Vim swap files, and the file it creates to check a directory is writable
+	"*.swp", "*.swo", "*.swx", "4913",
+	// Vim and Emacs backups
+	"*~",
+	// Emacs auto save files and lock files
+	"#*#", ".#*",
+	// JetBrains safe writes
+	"*___jb_tmp___", "*___jb_old___",
+}
+
+// WatchDir implements filepath.WalkFunc and adds paths to the filesystem watcher
+func (r *Rerun) WatchDir(path string, f os.FileInfo, err error) error {
+	// The path disappeared before it could be walked
+	if f == nil {
+		return err
+	}
+	if f.Mode()&os.ModeSymlink != 0 && r.FollowSymlinks {
+		return r.watchLink(path)
+	}
+	if f.IsDir() {
+		if reason := r.skipReason(path, f); reason != "" {
+			r.list(path, reason)
+			return filepath.SkipDir
+		}
+		// Don't watch the same directory twice when symlinks lead back to it
+		if r.FollowSymlinks && !r.markDir(path, f) {
+			log.WithField("path", path).Debugf("Already watching the directory %q links to", path)
+			return filepath.SkipDir
+		}
+		// Load the directory's .gitignore before any sub directories are walked
+		r.loadGitignore(path)
+		// Leave directories which haven't changed recently until there's
+		// activity next to them, still walking them for any which have
+		if r.Since > 0 && r.root(path) != path && time.Since(f.ModTime()) > r.Since {
+			log.WithFields(log.Fields{"path": path, "since": r.Since.String()}).
+				Debugf("Not watching %q directory yet since it hasn't changed within %s", path, r.Since)
+			r.stale[filepath.Dir(path)] = append(r.stale[filepath.Dir(path)], path)
+			r.list(path, SkippedSince)
+			return nil
+		}
+		// Leave sub directories and everything in them until there's activity
+		// next to them with WatchNewOnly
+		if r.lazy && r.root(path) != path {
+			log.WithField("path", path).Debugf("Not watching %q directory until something next to it changes", path)
+			r.stale[filepath.Dir(path)] = append(r.stale[filepath.Dir(path)], path)
+			r.list(path, SkippedWatchNewOnly)
+			return filepath.SkipDir
+		}
+		if r.listing {
+			r.list(path, "")
+			return nil
+		}
+		// The whole tree is polled instead of being watched
+		if r.watcher == nil {
+			return nil
+		}
+		return r.addWatch(path)
+	}
+	return err
+}
+
+// addWatch adds the directory to the filesystem watcher, polling it instead
+// if the watch limit has been reached
+func (r *Rerun) addWatch(path string) error {
+	err := r.watcher.Add(path)
+	if errors.Is(err, syscall.ENOSPC) {
+		r.warnWatchLimit()
+		return r.pollDir(path)
+	} else if err != nil {
+		log.WithFields(log.Fields{"path": path, "error": err.Error()}).Debugf("Unable to watch directory %q", path)
+	} else {
+		log.WithField("path", path).Debugf("Added %q directory to filesystem watcher", path)
+		r.watchedMu.Lock()
+		r.watched[path] = true
+		r.watchedMu.Unlock()
+	}
+	return err
+}
+
+// WatchedDirs returns the directories currently in the filesystem watcher,
+// sorted. It's empty when polling for changes
+func (r *Rerun) WatchedDirs() []string {
+	r.watchedMu.Lock()
+	defer r.watchedMu.Unlock()
+	dirs := make([]string, 0, len(r.watched))
+	for dir := range r.watched {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// watchStale watches the sub directories of the directory which were left
+// unwatched for Since or WatchNewOnly, now that something near them has
+// changed
+func (r *Rerun) watchStale(dir string) {
+	if r.watcher == nil {
+		return
+	}
+	stale := r.stale[dir]
+	delete(r.stale, dir)
+	for _, path := range stale {
+		r.addWatch(path)
+		if r.WatchNewOnly {
+			r.lazyChildren(path)
+		}
+	}
+}
+
+// lazyChildren leaves the sub directories of a newly watched directory until
+// there's activity next to them with WatchNewOnly, without walking any deeper
+func (r *Rerun) lazyChildren(path string) {
+	children, err := ioutil.ReadDir(path)
+	if err != nil {
+		log.WithField("path", path).Debugf("Unable to read directory %q", path)
+		return
+	}
+	r.lazy = true
+	defer func() { r.lazy = false }()
+	for _, child := range children {
+		if child.IsDir() {
+			r.WatchDir(filepath.Join(path, child.Name()), child, nil)
+		}
+	}
+}
+
+// WatchFileDir adds a directory containing watched files to the filesystem
+// watcher without walking it
+func (r *Rerun) WatchFileDir(path string) {
+	if r.listing {
+		r.list(path, "")
+		return
+	}
+	if r.watcher == nil {
+		return
+	}
+	r.addWatch(path)
+}
+
+// watchLink watches the directory a symlink points to, walking it as if it
+// were in the tree since filepath.Walk doesn't follow symlinks
+func (r *Rerun) watchLink(path string) error {
+	// Links to files and broken links are left to the events for the
+	// directory containing them
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return nil
+	}
+	err = r.WatchDir(path, info, nil)
+	if err == filepath.SkipDir {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	children, err := ioutil.ReadDir(path)
+	if err != nil {
+		log.Debugf("Unable to read directory %q", path)
+		return nil
+	}
+	for _, child := range children {
+		err = filepath.Walk(filepath.Join(path, child.Name()), r.WatchDir)
+		if err != nil {
+			log.Debugf("Unable to walk %q: %q", child.Name(), err)
+		}
+	}
+	return nil
+}
+
+// markDir records the path as watching its directory, reporting false if the
+// directory is already watched through another path. Directories are tracked
+// by device and inode number, so a symlink which leads back to an ancestor or
+// another watched directory is never walked again
+func (r *Rerun) markDir(path string, f os.FileInfo) bool {
+	key, ok := dirKey(path, f)
+	if !ok {
+		return true
+	}
+	if watched, ok := r.dirs[key]; ok && watched != path {
+		return false
+	}
+	r.dirs[key] = path
+	return true
+}
+
+// skipDir reports whether the directory and everything in it shouldn't be
+// watched
+func (r *Rerun) skipDir(path string, f os.FileInfo) bool {
+	return r.skipReason(path, f) != ""
+}
+
+// skipReason returns why the directory isn't watched or walked, empty if it
+// is
+func (r *Rerun) skipReason(path string, f os.FileInfo) string {
+	// Ignore .git directory since it's noisy, even with Hidden
+	if f.Name() == ".git" {
+		log.WithField("path", path).Debug("Ignoring .git directory")
+		return SkippedGit
+	}
+	// Ignore hidden directories such as .cache and .venv unless they're
+	// watched directly
+	if !r.Hidden && strings.HasPrefix(f.Name(), ".") && path != r.root(path) {
+		log.WithField("path", path).Debugf("Ignoring hidden directory %q", path)
+		return SkippedHidden
+	}
+	// Ignore directories matching an ignore pattern
+	if reason := r.ignoredDir(path); reason != "" {
+		log.WithField("path", path).Debugf("Ignoring %q directory", path)
+		return reason
+	}
+	// Only watch directories which could contain paths matching the Watch
+	// patterns in directories watched for them
+	if r.patternRoot(path) && path != r.root(path) && !r.patternCouldContain(path) {
+		log.WithField("path", path).Debugf("Not watching %q directory since nothing in it matches a watch pattern", path)
+		return SkippedWatchPattern
+	}
+	// Only watch the roots themselves when not recursive
+	if r.NoRecursive && path != r.root(path) {
+		log.WithField("path", path).Debugf("Not watching sub directory %q", path)
+		return SkippedDepth
+	}
+	if r.RecursiveDepth > 0 && r.depth(path) > r.RecursiveDepth {
+		log.WithFields(log.Fields{"path": path, "depth": r.RecursiveDepth}).
+			Debugf("Not watching %q directory since it's more than %d levels deep", path, r.RecursiveDepth)
+		return SkippedDepth
+	}
+	return ""
+}
+
+// ignoredDir returns which of the ignore patterns the directory matches, the
+// same as Ignored() along with IgnoreDirs, empty if none do
+func (r *Rerun) ignoredDir(path string) string {
+	switch {
+	case r.matches(r.Ignore, path):
+		return SkippedIgnore
+	case r.matches(r.IgnoreDirs, path):
+		return SkippedIgnoreDir
+	case !r.NoEditorIgnore && r.matches(EditorIgnore, path):
+		return SkippedEditor
+	case r.gitignored(path, true):
+		return SkippedGitignore
+	}
+	return ""
+}
+
+// depth returns how many levels below its watched root the path is
+func (r *Rerun) depth(path string) int {
+	rel := r.relPath(path)
+	if rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// pollDir polls the directory for changes instead of watching it
+func (r *Rerun) pollDir(path string) error {
+	err := r.poller.add(path)
+	if err != nil {
+		log.WithFields(log.Fields{"path": path, "error": err.Error()}).Debugf("Unable to poll directory %q", path)
+	} else {
+		log.WithField("path", path).Debugf("Polling %q directory for changes", path)
+	}
+	return err
+}
+
+// warnWatchLimit warns that the inotify watch limit has been reached, once
+func (r *Rerun) warnWatchLimit() {
+	r.watchLimitOnce.Do(func() {
+		log.Warn("The inotify watch limit has been reached, so directories which can't be " +
+			"watched will be polled for changes instead. To watch more directories, raise " +
+			"the limit with: sudo sysctl fs.inotify.max_user_watches=524288")
+	})
+}
+
+// UnwatchDir removes paths from the filesystem watcher
+func (r *Rerun) UnwatchDir(path string) {
+	if r.watcher == nil {
+		return
+	}
+	err := r.watcher.Remove(path)
+	if err == nil {
+		log.WithField("path", path).Debugf("Removed %q directory from filesystem watcher", path)
+	}
+	if r.poller.remove(path) {
+		log.WithField("path", path).Debugf("Stopped polling %q directory", path)
+	}
+	delete(r.stale, path)
+	// The watches on anything inside the directory went with it
+	r.watchedMu.Lock()
+	for watched := range r.watched {
+		if watched == path || strings.HasPrefix(watched, path+string(filepath.Separator)) {
+			delete(r.watched, watched)
+		}
+	}
+	r.watchedMu.Unlock()
+	// Forget the removed directories so their inodes can be reused
+	for key, watched := range r.dirs {
+		if watched == path || strings.HasPrefix(watched, path+string(filepath.Separator)) {
+			delete(r.dirs, key)
+		}
+	}
+}
+
+// Ignored reports whether the path matches any of the ignore patterns, is an
+// editor's temporary file or is ignored by a .gitignore file
+func (r *Rerun) Ignored(path string) bool {
+	if r.matches(r.Ignore, path) {
+		return true
+	}
+	if !r.NoEditorIgnore && r.matches(EditorIgnore, path) {
+		return true
+	}
+	info, err := os.Stat(path)
+	return r.gitignored(path, err == nil && info.IsDir())
+}
+
+// inIgnoredDir reports whether the path is a directory matching one of the
+// IgnoreDirs patterns or is inside one
+func (r *Rerun) inIgnoredDir(path string) bool {
+	if len(r.IgnoreDirs) == 0 {
+		return false
+	}
+	// Watched files outside the roots aren't in any ignored directory
+	root := r.root(path)
+	if root == "" {
+		return false
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() && r.matches(r.IgnoreDirs, path) {
+		return true
+	}
+	for dir := filepath.Dir(path); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if r.matches(r.IgnoreDirs, dir) {
+			return true
+		}
+		// Stop at the top of the filesystem
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	return false
+}
+
+// Included reports whether the path matches one of the include patterns, or
+// true when there are no include patterns
+func (r *Rerun) Included(path string) bool {
+	return len(r.Include) == 0 || r.matches(r.Include, path)
+}
+
+// root returns the watched root directory containing path
+func (r *Rerun) root(path string) string {
+	var root string
+	for _, dir := range r.roots {
+		if (path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))) && len(dir) > len(root) {
+			root = dir
+		}
+	}
+	return root
+}
+
+// relPath returns the path relative to the watched root containing it
+func (r *Rerun) relPath(path string) string {
+	rel, err := filepath.Rel(r.root(path), path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// matches reports whether the base name of path or the path relative to the
+// watch root matches any of the glob patterns. A trailing slash on a pattern
+// is ignored so "dist/" matches the dist directory.
+func (r *Rerun) matches(patterns []string, path string) bool {
+	base := filepath.Base(path)
+	rel := r.relPath(path)
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether the paths include the path
+func contains(paths []string, path string) bool {
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,376 @@
+package rerun
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestRenamedDirIsWatched(t *testing.T) {
+	dir := t.TempDir()
+	mkdirs(t, dir, "sub")
+	r := newTestRerun(t, testConfig(t, dir, "true"))
+	runRerun(t, r)
+	waitRun(t, r)
+
+	if err := os.Rename(filepath.Join(dir, "sub"), filepath.Join(dir, "moved")); err != nil {
+		t.Fatal(err)
+	}
+	waitRun(t, r)
+	noEvent(t, r, CommandStarted)
+	if !watching(r, filepath.Join(dir, "moved")) || watching(r, filepath.Join(dir, "sub")) {
+		t.Errorf("Watching %q after renaming sub to moved", r.WatchedDirs())
+	}
+
+	writeFile(t, dir, "moved/file.txt")
+	changed := waitEvent(t, r, FileChanged)
+	if want := filepath.Join(dir, "moved", "file.txt"); changed.Path != want {
+		t.Errorf("Changed path = %q, want %q", changed.Path, want)
+	}
+	waitRun(t, r)
+}
+
+func TestEditorIgnore(t *testing.T) {
+	dir := t.TempDir()
+	config := testConfig(t, dir, "true")
+	r := newTestRerun(t, config)
+	config.NoEditorIgnore = true
+	all := newTestRerun(t, config)
+
+	for _, test := range []struct {
+		name    string
+		ignored bool
+	}{
+		{".main.go.swp", true},
+		{".main.go.swo", true},
+		{".main.go.swx", true},
+		{"4913", true},
+		{"main.go~", true},
+		{"#main.go#", true},
+		{".#main.go", true},
+		{"main.go___jb_tmp___", true},
+		{"main.go___jb_old___", true},
+		{"sub/.main.go.swp", true},
+		{"main.go", false},
+		{"swp.go", false},
+		{"4913.go", false},
+		{"#main.go", false},
+		{"main~.go", false},
+		{"sub/main.go", false},
+	} {
+		path := filepath.Join(dir, test.name)
+		if got := r.Ignored(path); got != test.ignored {
+			t.Errorf("Ignored(%q) = %v, want %v", test.name, got, test.ignored)
+		}
+		if all.Ignored(path) {
+			t.Errorf("Ignored(%q) = true with NoEditorIgnore", test.name)
+		}
+	}
+}
+
+// mkTree creates a tree of directories, each with width sub directories, to
+// the depth
+func mkTree(t testing.TB, dir string, width, depth int) {
+	t.Helper()
+	if depth == 0 {
+		return
+	}
+	for i := 0; i < width; i++ {
+		name := fmt.Sprintf("dir%d", i)
+		mkdirs(t, dir, name)
+		mkTree(t, filepath.Join(dir, name), width, depth-1)
+	}
+}
+
+func BenchmarkNewRerun(b *testing.B) {
+	dir := b.TempDir()
+	mkTree(b, dir, 10, 2)
+	for _, bench := range []struct {
+		name         string
+		watchNewOnly bool
+	}{
+		{"eager", false},
+		{"watch-new-only", true},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			config := testConfig(b, dir, "true")
+			config.WatchNewOnly = bench.watchNewOnly
+			for i := 0; i < b.N; i++ {
+				r, err := NewRerun(config)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				r.cleanup()
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func BenchmarkHandleEvent(b *testing.B) {
+	dir := b.TempDir()
+	mkTree(b, dir, 10, 2)
+	config := testConfig(b, dir, "true")
+	config.Ignore = []string{"*.log", "dist"}
+	r := newTestRerun(b, config)
+	path := filepath.Join(dir, "dir5", "dir5", "main.go")
+	writeFile(b, dir, "dir5/dir5/main.go")
+	event := fsnotify.Event{Name: path, Op: fsnotify.Write}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !r.handleEvent(event) {
+			b.Fatal("Event didn't rerun the command")
+		}
+	}
+}
+
+func TestHiddenDirs(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		hidden bool
+	}{
+		{"skipped by default", false},
+		{"watched with Hidden", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			mkdirs(t, dir, ".venv/lib", ".cache", ".idea", "src", ".git")
+			config := testConfig(t, dir, "true")
+			config.Hidden = test.hidden
+			r := newTestRerun(t, config)
+
+			if !watching(r, filepath.Join(dir, "src")) {
+				t.Errorf("src isn't watched, watching %q", r.WatchedDirs())
+			}
+			for _, path := range []string{".venv", ".venv/lib", ".cache", ".idea"} {
+				if got := watching(r, filepath.Join(dir, path)); got != test.hidden {
+					t.Errorf("Watching %q = %v, want %v", path, got, test.hidden)
+				}
+			}
+			// .git is never watched
+			if watching(r, filepath.Join(dir, ".git")) {
+				t.Error(".git is watched")
+			}
+		})
+	}
+}
+
+func TestHiddenRootIsWatched(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), ".config")
+	mkdirs(t, dir, "sub")
+	r := newTestRerun(t, testConfig(t, dir, "true"))
+
+	for _, path := range []string{dir, filepath.Join(dir, "sub")} {
+		if !watching(r, path) {
+			t.Errorf("%q isn't watched, watching %q", path, r.WatchedDirs())
+		}
+	}
+}
+
+func TestIgnoreDirsAreNotWatched(t *testing.T) {
+	dir := t.TempDir()
+	mkdirs(t, dir, "node_modules/pkg/lib", "src/node_modules/pkg", "src/lib")
+	config := testConfig(t, dir, "true")
+	config.IgnoreDirs = []string{"node_modules"}
+	r := newTestRerun(t, config)
+	runRerun(t, r)
+	waitRun(t, r)
+
+	// Including those created while running
+	mkdirs(t, dir, "app/node_modules/pkg")
+	waitRun(t, r)
+	noEvent(t, r, CommandStarted)
+
+	for _, path := range []string{"", "src", "src/lib", "app"} {
+		if !watching(r, filepath.Join(dir, path)) {
+			t.Errorf("%q isn't watched, watching %q", path, r.WatchedDirs())
+		}
+	}
+	for _, path := range []string{
+		"node_modules", "node_modules/pkg", "node_modules/pkg/lib",
+		"src/node_modules", "src/node_modules/pkg",
+		"app/node_modules", "app/node_modules/pkg",
+	} {
+		if watching(r, filepath.Join(dir, path)) {
+			t.Errorf("%q is watched", path)
+		}
+	}
+}
+
+func TestNewTreeIsWatched(t *testing.T) {
+	dir := t.TempDir()
+	config := testConfig(t, dir, "true")
+	// Events for the file may also arrive once its directory is watched
+	config.Debounce = 100 * time.Millisecond
+	r := newTestRerun(t, config)
+	runRerun(t, r)
+	waitRun(t, r)
+
+	// The file is written before the new directories can be watched
+	mkdirs(t, dir, "a/b/c")
+	writeFile(t, dir, "a/b/c/f")
+	file := filepath.Join(dir, "a", "b", "c", "f")
+	found := false
+	for !found {
+		found = waitEvent(t, r, FileChanged).Path == file
+	}
+	waitRun(t, r)
+	noEvent(t, r, CommandStarted)
+	for _, path := range []string{"a", "a/b", "a/b/c"} {
+		if !watching(r, filepath.Join(dir, path)) {
+			t.Errorf("%q isn't watched, watching %q", path, r.WatchedDirs())
+		}
+	}
+
+	writeFile(t, dir, "a/b/c/g")
+	if changed := waitEvent(t, r, FileChanged); changed.Path != filepath.Join(dir, "a", "b", "c", "g") {
+		t.Errorf("Changed path = %q, want a/b/c/g", changed.Path)
+	}
+	waitRun(t, r)
+}
+
+func TestRecursiveDepth(t *testing.T) {
+	dir := t.TempDir()
+	mkdirs(t, dir, "a/b/c", "d")
+	for _, test := range []struct {
+		name        string
+		noRecursive bool
+		depth       int
+		want        []ListedDir
+	}{
+		{"depth 0", true, 0, []ListedDir{
+			{Path: "", Watched: true},
+			{Path: "a", Reason: SkippedDepth},
+			{Path: "d", Reason: SkippedDepth},
+		}},
+		{"depth 1", false, 1, []ListedDir{
+			{Path: "", Watched: true},
+			{Path: "a", Watched: true},
+			{Path: "a/b", Reason: SkippedDepth},
+			{Path: "d", Watched: true},
+		}},
+		{"depth 2", false, 2, []ListedDir{
+			{Path: "", Watched: true},
+			{Path: "a", Watched: true},
+			{Path: "a/b", Watched: true},
+			{Path: "a/b/c", Reason: SkippedDepth},
+			{Path: "d", Watched: true},
+		}},
+		{"unlimited", false, 0, []ListedDir{
+			{Path: "", Watched: true},
+			{Path: "a", Watched: true},
+			{Path: "a/b", Watched: true},
+			{Path: "a/b/c", Watched: true},
+			{Path: "d", Watched: true},
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			config := testConfig(t, dir, "true")
+			config.NoRecursive = test.noRecursive
+			config.RecursiveDepth = test.depth
+			listed, err := ListAllDirs(config)
+			if err != nil {
+				t.Fatalf("ListAllDirs() failed: %v", err)
+			}
+			for i := range test.want {
+				test.want[i].Path = filepath.Join(dir, test.want[i].Path)
+			}
+			if !reflect.DeepEqual(listed, test.want) {
+				t.Errorf("ListAllDirs() = %+v, want %+v", listed, test.want)
+			}
+		})
+	}
+}
+
+func TestRecursiveDepthNewDirs(t *testing.T) {
+	dir := t.TempDir()
+	config := testConfig(t, dir, "true")
+	config.RecursiveDepth = 1
+	r := newTestRerun(t, config)
+	runRerun(t, r)
+	waitRun(t, r)
+
+	mkdirs(t, dir, "x/y/z")
+	waitRun(t, r)
+	if !watching(r, filepath.Join(dir, "x")) {
+		t.Errorf("x isn't watched, watching %q", r.WatchedDirs())
+	}
+	for _, path := range []string{"x/y", "x/y/z"} {
+		if watching(r, filepath.Join(dir, path)) {
+			t.Errorf("%q is watched deeper than RecursiveDepth", path)
+		}
+	}
+}
+
+func TestAtomicSaveRerunsOnce(t *testing.T) {
+	rename := func(t *testing.T, dir, from, to string) {
+		t.Helper()
+		if err := os.Rename(filepath.Join(dir, from), filepath.Join(dir, to)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		name string
+		save func(t *testing.T, dir string)
+	}{
+		{"rename over", func(t *testing.T, dir string) {
+			writeFile(t, dir, "file.txt___jb_tmp___")
+			rename(t, dir, "file.txt___jb_tmp___", "file.txt")
+		}},
+		// JetBrains moves the file away before moving the new one in
+		{"move away first", func(t *testing.T, dir string) {
+			writeFile(t, dir, "file.txt___jb_tmp___")
+			rename(t, dir, "file.txt", "file.txt___jb_old___")
+			rename(t, dir, "file.txt___jb_tmp___", "file.txt")
+			if err := os.Remove(filepath.Join(dir, "file.txt___jb_old___")); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, dir, "file.txt")
+			r := newTestRerun(t, testConfig(t, dir, "true"))
+			runRerun(t, r)
+			waitRun(t, r)
+
+			test.save(t, dir)
+			if changed := waitEvent(t, r, FileChanged); changed.Path != filepath.Join(dir, "file.txt") {
+				t.Errorf("Changed path = %q, want file.txt", changed.Path)
+			}
+			waitRun(t, r)
+			noEvent(t, r, CommandStarted)
+		})
+	}
+}
+
+func TestWatchFileWithIgnoreDirs(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "watched.txt")
+	config := testConfig(t, dir, "true")
+	config.Dirs = nil
+	config.Files = []string{filepath.Join(dir, "watched.txt")}
+	config.IgnoreDirs = []string{"vendor"}
+	r := newTestRerun(t, config)
+
+	// The file isn't in any watched root, so there's none to stop looking
+	// for ignored directories at
+	handled := make(chan bool, 1)
+	go func() {
+		handled <- r.handleEvent(fsnotify.Event{Name: filepath.Join(dir, "watched.txt"), Op: fsnotify.Write})
+	}()
+	select {
+	case reruns := <-handled:
+		if !reruns {
+			t.Error("Change to the watched file didn't rerun the command")
+		}
+	case <-time.After(eventTimeout):
+		t.Fatal("handleEvent() didn't return")
+	}
+}
@@ -0,0 +1,162 @@
+package rerun
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// readWatchList reads the paths and globs listed in the file, one per line,
+// leaving out blank lines and comments starting with #. Relative entries are
+// relative to the file's directory and directories are watched recursively
+func readWatchList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the watch list %q: %q", path, err)
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := filepath.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("Invalid pattern %q in the watch list %q", line, path)
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(filepath.Dir(path), line)
+		}
+		line = filepath.Clean(line)
+		if info, err := os.Stat(line); err == nil && info.IsDir() {
+			line = filepath.Join(line, "**")
+		}
+		patterns = append(patterns, filepath.ToSlash(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read the watch list %q: %q", path, err)
+	}
+	return patterns, nil
+}
+
+// resolveWatchList reads WatchFrom, watching the patterns listed in it along
+// with the Watch patterns and the file itself, which is watched like Files
+func (r *Rerun) resolveWatchList() error {
+	path, err := filepath.Abs(r.WatchFrom)
+	if err != nil {
+		return fmt.Errorf("Unable to determine absolute path of %q: %q", r.WatchFrom, err)
+	}
+	r.watchFrom = path
+	r.watchPatterns = r.patterns
+	patterns, err := readWatchList(path)
+	if err != nil {
+		return err
+	}
+	r.setWatchList(patterns)
+	r.watchFiles[path] = true
+	return nil
+}
+
+// reloadWatchList reads WatchFrom again after it changed, watching newly
+// listed paths and no longer watching those which aren't listed any more
+func (r *Rerun) reloadWatchList() {
+	patterns, err := readWatchList(r.watchFrom)
+	if err != nil {
+		log.WithFields(log.Fields{"path": r.watchFrom, "error": err}).
+			Warnf("Unable to reload the watch list, so the paths watched are unchanged: %q", err)
+		return
+	}
+	walk := r.setWatchList(patterns)
+	for _, root := range walk {
+		r.loadParentGitignores(root)
+		err := filepath.Walk(root, r.WatchDir)
+		if err != nil {
+			log.Debugf("Unable to walk %q: %q", root, err)
+		}
+	}
+	r.unwatchUnlisted()
+	if r.watcher == nil {
+		r.files = r.snapshot()
+	}
+	log.WithFields(log.Fields{"path": r.watchFrom, "patterns": len(patterns)}).
+		Infof("Reloaded the watch list %q, watching %d paths", r.watchFrom, len(patterns))
+}
+
+// setWatchList replaces the patterns from WatchFrom with the new list,
+// returning the roots to walk for them
+func (r *Rerun) setWatchList(patterns []string) []string {
+	r.patterns = append(append([]string(nil), r.watchPatterns...), patterns...)
+	var walk []string
+	for _, pattern := range patterns {
+		root := globRoot(filepath.FromSlash(pattern))
+		if existing := r.root(root); existing != "" {
+			// The directories already watched for patterns may have skipped
+			// those which could only match this one
+			if r.patternRoots[existing] && !contains(walk, existing) {
+				walk = append(walk, existing)
+			}
+			continue
+		}
+		log.WithField("path", root).Debugf("Watching %q for paths matching %q", root, pattern)
+		r.roots = append(r.roots, root)
+		r.patternRoots[root] = true
+		walk = append(walk, root)
+	}
+	return walk
+}
+
+// unwatchUnlisted stops watching the directories which were only watched for
+// patterns which are no longer listed
+func (r *Rerun) unwatchUnlisted() {
+	var roots []string
+	for _, root := range r.roots {
+		if r.patternRoots[root] && !r.rootListed(root) {
+			log.WithField("path", root).Debugf("No longer watching %q since no pattern needs it", root)
+			delete(r.patternRoots, root)
+			continue
+		}
+		roots = append(roots, root)
+	}
+	r.roots = roots
+	for _, dir := range r.WatchedDirs() {
+		root := r.root(dir)
+		if root == "" || r.patternRoots[root] && dir != root && !r.patternCouldContain(dir) {
+			r.unwatch(dir)
+		}
+	}
+}
+
+// rootListed reports whether any of the patterns is in the root
+func (r *Rerun) rootListed(root string) bool {
+	for _, pattern := range r.patterns {
+		dir := globRoot(filepath.FromSlash(pattern))
+		if dir == root || strings.HasPrefix(dir, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// unwatch removes just the directory from the filesystem watcher, unlike
+// UnwatchDir which forgets everything inside it too
+func (r *Rerun) unwatch(dir string) {
+	// Keep watching the directories containing watched files
+	for file := range r.watchFiles {
+		if filepath.Dir(file) == dir {
+			return
+		}
+	}
+	if err := r.watcher.Remove(dir); err == nil {
+		log.WithField("path", dir).Debugf("Removed %q directory from filesystem watcher", dir)
+	}
+	r.poller.remove(dir)
+	r.watchedMu.Lock()
+	delete(r.watched, dir)
+	r.watchedMu.Unlock()
+}